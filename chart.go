@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/wcharczuk/go-chart/v2"
+)
+
+// renderChart draws a time series chart as a PNG at path
+//
+// The PNG is written to a temporary file and renamed into place, so
+// anything reading path never sees a half written chart.
+func renderChart(path string, metric *Metric, xaxis []time.Time, values []float64) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".chart*.png")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = writeChart(f, metric, xaxis, values)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(f.Name(), 0666)
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// writeChart draws a time series chart as a PNG to w
+func writeChart(w io.Writer, metric *Metric, xaxis []time.Time, values []float64) error {
+	graph := chart.Chart{
+		Title:      metric.ChartTitle,
+		Background: chart.Style{Padding: chart.Box{Top: 20, Left: 20, Right: 20, Bottom: 20}},
+		XAxis: chart.XAxis{
+			Style: chart.Style{TextRotationDegrees: 90.0, FontSize: 6},
+			ValueFormatter: func(v interface{}) string {
+				typed := v.(float64)
+				typedDate := chart.TimeFromFloat64(typed)
+				return typedDate.Format("Jan-02-06 15:04")
+			},
+		},
+		YAxis: chart.YAxis{
+			Name:      metric.AxisName(),
+			NameStyle: chart.Style{FontColor: chart.ColorBlack},
+		},
+		Series: []chart.Series{
+			chart.TimeSeries{
+				YAxis:   chart.YAxisPrimary,
+				XValues: xaxis,
+				YValues: values,
+				Style:   metric.ChartStyle(),
+			},
+		},
+	}
+	return graph.Render(chart.PNG, w)
+}
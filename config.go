@@ -0,0 +1,318 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfig reads the YAML file named by --config, if any, and uses it
+// as the defaults for the command line options.
+//
+// Keys are the long option names, with nested maps for option groups, so
+//
+//	i2c:
+//	  device: /dev/i2c-1
+//
+// sets --i2c.device.  Anything given on the command line still wins, and
+// "airquality config check" prints the merged result.
+//
+// Like the credentials file, a config file setting a password or secret
+// key must only be readable by its owner.
+func loadConfig(p *flags.Parser, args []string) error {
+	var configOptions struct {
+		Config string `short:"c" long:"config"`
+	}
+	_, err := flags.NewParser(&configOptions, flags.IgnoreUnknown).ParseArgs(args)
+	if err != nil || configOptions.Config == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(configOptions.Config)
+	if err != nil {
+		return err
+	}
+	var settings map[string]interface{}
+	err = yaml.Unmarshal(data, &settings)
+	if err != nil {
+		return fmt.Errorf("%s: %v", configOptions.Config, err)
+	}
+
+	values := map[string][]string{}
+	flattenConfig("", settings, values)
+
+	for _, name := range secretSettings {
+		if _, ok := values[name]; ok {
+			err = checkPrivate(configOptions.Config)
+			if err != nil {
+				return fmt.Errorf("%v, as it sets %s", err, name)
+			}
+			break
+		}
+	}
+
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		option := p.FindOptionByLongName(name)
+		if option == nil || name == "config" {
+			return fmt.Errorf("%s: unknown setting %q", configOptions.Config, name)
+		}
+		err = checkSetting(name, values[name])
+		if err != nil {
+			return fmt.Errorf("%s: %v", configOptions.Config, err)
+		}
+		option.Default = values[name]
+	}
+	return nil
+}
+
+// secretSettings are the settings a config file can only hold if it is
+// private
+var secretSettings = []string{"password", "upload.password", "upload.secret-key"}
+
+// checkSetting makes sure each value can be given to the named option, by
+// giving it to a spare parser
+func checkSetting(name string, values []string) error {
+	check := newParser(&Options{}, flags.None)
+	option := check.FindOptionByLongName(name)
+	for _, value := range values {
+		if _, ok := option.Value().(bool); ok {
+			_, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid value %q for %s, expected true or false", value, name)
+			}
+			continue
+		}
+		_, err := check.ParseArgs([]string{"--" + name + "=" + value})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flattenConfig turns nested YAML maps into option names and values
+func flattenConfig(prefix string, settings map[string]interface{}, values map[string][]string) {
+	for key, value := range settings {
+		name := prefix + key
+		switch v := value.(type) {
+		case map[string]interface{}:
+			flattenConfig(name+".", v, values)
+		case []interface{}:
+			for _, item := range v {
+				values[name] = append(values[name], fmt.Sprint(item))
+			}
+		case nil:
+			values[name] = nil
+		default:
+			values[name] = []string{fmt.Sprint(v)}
+		}
+	}
+}
+
+// defaultThresholds are the chart thresholds in the metrics table, before
+// any options change them
+var defaultThresholds = func() map[string][]string {
+	thresholds := map[string][]string{}
+	for _, metric := range metrics {
+		for _, threshold := range metric.Thresholds {
+			thresholds[metric.Key] = append(thresholds[metric.Key], strconv.FormatFloat(threshold, 'f', -1, 64))
+		}
+	}
+	return thresholds
+}()
+
+// newParser returns a parser for opts with a chart thresholds option for
+// each metric in the metrics table that has thresholds, defaulting to them
+func newParser(opts *Options, flagOptions flags.Options) *flags.Parser {
+	p := flags.NewParser(opts, flagOptions)
+	p.SubcommandsOptional = true
+
+	// go-flags can only set a struct field or call a function, so each
+	// option appends to its metric's thresholds
+	//
+	opts.Thresholds.values = map[string][]float64{}
+	group := p.Command.Group.Find("Chart Threshold Options")
+	for _, metric := range metrics {
+		thresholds, ok := defaultThresholds[metric.Key]
+		if !ok {
+			continue
+		}
+		key := metric.Key
+		group.AddOption(&flags.Option{
+			LongName:    key,
+			Description: metric.Name + " chart colour thresholds",
+			Default:     thresholds,
+		}, func(value string) error {
+			threshold, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("%q is not a number", value)
+			}
+			opts.Thresholds.values[key] = append(opts.Thresholds.values[key], threshold)
+			return nil
+		})
+	}
+	return p
+}
+
+// checkOptions makes sure the options make sense together
+func checkOptions(o *Options) error {
+	if o.Broker == "" || o.Username == "" || o.Password == "" {
+		return fmt.Errorf("broker, username and password must be given on the command line, in the environment, in a credentials file or in the config file")
+	}
+	if (o.TLS.Cert == "") != (o.TLS.Key == "") {
+		return fmt.Errorf("tls.cert and tls.key must be given together")
+	}
+	if o.Sensor == "replay" && o.ReplayFile == "" {
+		return fmt.Errorf("replay-file is required with sensor: replay")
+	}
+	if o.Store.RawDays < 0 || o.Store.AggregateDays < 0 || o.Store.HourlyDays < 0 {
+		return fmt.Errorf("retention days must not be negative")
+	}
+	if o.Store.Aggregate <= 0 || o.Store.CompactInterval <= 0 {
+		return fmt.Errorf("store.aggregate and store.compact-interval must be greater than 0")
+	}
+	if o.Interval.Sample <= 0 || o.Interval.Publish <= 0 || o.Interval.Render <= 0 || o.Interval.Upload <= 0 {
+		return fmt.Errorf("intervals must be greater than 0")
+	}
+	if o.ReplaySpeed <= 0 {
+		return fmt.Errorf("replay-speed must be greater than 0")
+	}
+	if o.Queue.Size <= 0 {
+		return fmt.Errorf("queue.size must be greater than 0")
+	}
+	if o.HTTP.Refresh != 0 && o.HTTP.Refresh < time.Second {
+		return fmt.Errorf("http.refresh must be 0 or at least 1s")
+	}
+	if o.ClientID == "" {
+		return fmt.Errorf("client-id must not be empty")
+	}
+	switch {
+	case o.Upload.Publisher == "sftp" && o.Upload.Host == "":
+		return fmt.Errorf("upload.host is required with upload.publisher: sftp")
+	case o.Upload.Publisher == "local" && o.Upload.Dir == "":
+		return fmt.Errorf("upload.dir is required with upload.publisher: local")
+	case o.Upload.Publisher == "s3" && (o.Upload.Endpoint == "" || o.Upload.Bucket == ""):
+		return fmt.Errorf("upload.endpoint and upload.bucket are required with upload.publisher: s3")
+	case o.Upload.Publisher == "s3" && (o.Upload.AccessKey == "" || o.Upload.SecretKey == ""):
+		return fmt.Errorf("upload.access-key and upload.secret-key are required with upload.publisher: s3")
+	case o.Upload.Publisher == "webdav" && o.Upload.URL == "":
+		return fmt.Errorf("upload.url is required with upload.publisher: webdav")
+	case o.Upload.Publisher == "command" && o.Upload.Command == "":
+		return fmt.Errorf("upload.command is required with upload.publisher: command")
+	}
+
+	for _, metric := range metrics {
+		if _, ok := defaultThresholds[metric.Key]; !ok {
+			continue
+		}
+		thresholds := o.Thresholds.Get(metric.Key)
+		if len(thresholds) != len(metric.Colors)-1 {
+			return fmt.Errorf("thresholds.%s needs %d values, one for each colour change, but has %d", metric.Key, len(metric.Colors)-1, len(thresholds))
+		}
+		for i := 1; i < len(thresholds); i++ {
+			if thresholds[i] <= thresholds[i-1] {
+				return fmt.Errorf("thresholds.%s must be in increasing order", metric.Key)
+			}
+		}
+	}
+	return nil
+}
+
+// applyThresholds copies the chart thresholds options to the metrics table
+func applyThresholds(o *Options) {
+	for i := range metrics {
+		if _, ok := defaultThresholds[metrics[i].Key]; ok {
+			metrics[i].Thresholds = append([]float64(nil), o.Thresholds.Get(metrics[i].Key)...)
+		}
+	}
+}
+
+// ConfigCommand holds the configuration commands
+type ConfigCommand struct {
+	Check ConfigCheckCommand `command:"check" description:"Check the configuration and print the effective settings"`
+}
+
+// ConfigCheckCommand checks the configuration and prints the result of
+// merging the config file, credentials, environment and command line
+type ConfigCheckCommand struct{}
+
+func (c *ConfigCheckCommand) Execute(args []string) error {
+	err := checkOptions(&options)
+	if err != nil {
+		return err
+	}
+	return printConfig(os.Stdout)
+}
+
+// printConfig writes the effective options as a config file
+func printConfig(w io.Writer) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	err := encoder.Encode(configNode(parser.Command.Group))
+	if err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// configNode returns a YAML mapping of a group's options and subgroups, in
+// the order they are declared
+func configNode(group *flags.Group) *yaml.Node {
+	node := &yaml.Node{Kind: yaml.MappingNode}
+	for _, option := range group.Options() {
+		if option.LongName == "config" || option.LongName == "help" {
+			continue
+		}
+		v := option.Value()
+		if _, ok := v.(func(string) error); ok {
+			// a thresholds option, see newParser
+			v = options.Thresholds.Get(option.LongName)
+		}
+		value := &yaml.Node{Kind: yaml.ScalarNode}
+		switch v := v.(type) {
+		case string:
+			value.Tag = "!!str"
+			value.Value = v
+			if (option.LongName == "password" || option.LongName == "secret-key") && v != "" {
+				value.Value = "********"
+			}
+		case time.Duration:
+			value.Value = v.String()
+		case []float64:
+			value.Kind = yaml.SequenceNode
+			value.Style = yaml.FlowStyle
+			for _, f := range v {
+				value.Content = append(value.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: strconv.FormatFloat(f, 'f', -1, 64)})
+			}
+		case []string:
+			value.Kind = yaml.SequenceNode
+			value.Style = yaml.FlowStyle
+			for _, s := range v {
+				value.Content = append(value.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: s})
+			}
+		default:
+			value.Value = fmt.Sprint(v)
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: option.LongName}, value)
+	}
+	for _, sub := range group.Groups() {
+		if sub.Namespace == "" {
+			// the top level options, and help
+			node.Content = append(node.Content, configNode(sub).Content...)
+			continue
+		}
+		node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: sub.Namespace}, configNode(sub))
+	}
+	return node
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+)
+
+func TestLoadConfigSecrets(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		setting string
+		mode    os.FileMode
+		wantErr string
+	}{
+		{"no secrets", "broker: tcp://localhost:1883\n", 0644, ""},
+		{"private password", "password: secret\n", 0600, ""},
+		{"password", "password: secret\n", 0644, "as it sets password"},
+		{"webdav password", "upload:\n  password: secret\n", 0640, "as it sets upload.password"},
+		{"s3 secret key", "upload:\n  secret-key: secret\n", 0604, "as it sets upload.secret-key"},
+	} {
+		path := filepath.Join(t.TempDir(), "airquality.yaml")
+		err := os.WriteFile(path, []byte(test.setting), test.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.Chmod(path, test.mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = loadConfig(newParser(&Options{}, flags.None), []string{"--config", path})
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+			t.Errorf("%s: got %v, want an error ending %q", test.name, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/jessevdk/go-flags"
+	"gopkg.in/yaml.v3"
+)
+
+// loadCredentials uses the broker credentials from systemd and from the
+// file named by --credentials, if any, as the defaults for --username and
+// --password
+//
+// systemd credentials are the files mqtt-username and mqtt-password in
+// $CREDENTIALS_DIRECTORY, set up with LoadCredential= in the unit.  The
+// credentials file is YAML
+//
+//	username: airquality
+//	password: secret
+//
+// and must only be readable by its owner.  Both override the config file,
+// and the environment and command line override them.
+func loadCredentials(p *flags.Parser, args []string) error {
+	if dir := os.Getenv("CREDENTIALS_DIRECTORY"); dir != "" {
+		for name, option := range map[string]string{"mqtt-username": "username", "mqtt-password": "password"} {
+			data, err := os.ReadFile(filepath.Join(dir, name))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			setDefault(p, option, strings.TrimRight(string(data), "\r\n"))
+		}
+	}
+
+	path, err := credentialsPath(p, args)
+	if err != nil || path == "" {
+		return err
+	}
+	err = checkPrivate(path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var credentials map[string]string
+	err = yaml.Unmarshal(data, &credentials)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	for name, value := range credentials {
+		if name != "username" && name != "password" {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		setDefault(p, name, value)
+	}
+	return nil
+}
+
+// credentialsPath returns the credentials file from the command line, the
+// environment or the config file, in that order
+func credentialsPath(p *flags.Parser, args []string) (string, error) {
+	var credentialsOptions struct {
+		Credentials string `long:"credentials"`
+	}
+	_, err := flags.NewParser(&credentialsOptions, flags.IgnoreUnknown).ParseArgs(args)
+	if err == nil && credentialsOptions.Credentials != "" {
+		return credentialsOptions.Credentials, nil
+	}
+
+	option := p.FindOptionByLongName("credentials")
+	if path := os.Getenv(option.EnvDefaultKey); path != "" {
+		return path, nil
+	}
+	if len(option.Default) > 0 {
+		return option.Default[0], nil
+	}
+	return "", nil
+}
+
+// checkPrivate makes sure nobody but us can read or change a file
+func checkPrivate(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("%s must only be accessible by its owner, it has mode %04o", path, info.Mode().Perm())
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Getuid() && stat.Uid != 0 {
+		return fmt.Errorf("%s must be owned by us or root, it is owned by uid %d", path, stat.Uid)
+	}
+	return nil
+}
+
+// setDefault sets the default value of an option
+func setDefault(p *flags.Parser, name string, value string) {
+	p.FindOptionByLongName(name).Default = []string{value}
+}
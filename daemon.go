@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Daemon holds the state shared by the scheduled jobs
+type Daemon struct {
+	sensor   Sensor
+	info     SensorInfo
+	client   mqtt.Client
+	recorder *Recorder
+	store    *Store
+	history  *History
+	queue    *Queue
+
+	scheduler *Scheduler
+
+	// set once the sensor has been stopped, by shutdown or its timeout
+	sensorStopped uint32
+
+	// publisher is nil if the charts are not published
+	publisher Publisher
+
+	// server is nil if the dashboard is not served
+	server *DashboardServer
+
+	handled time.Time // last measurement published, queued or dropped, only used by publish
+	cancel  context.CancelFunc
+
+	// charts are rendered into a directory per day under workdir and
+	// uploaded from there
+	workdir string
+	mu      sync.Mutex
+	charts  map[string]renderedChart
+	status  *uint32
+
+	// days a dashboard page has been published for, only used by upload
+	pageDays map[string]time.Time
+}
+
+// sample reads the sensor and saves the measurement
+func (d *Daemon) sample() {
+	m, err := d.sensor.Read()
+	if err == io.EOF {
+		fmt.Printf("Replay finished\n")
+		d.cancel()
+		return
+	}
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		return
+	}
+
+	// a replay is read at the recorded pace rather than every sample
+	// interval, but tickers need a positive interval
+	//
+	if r, ok := d.sensor.(*ReplaySensor); ok {
+		if interval, ok := r.Next(); ok {
+			if interval < time.Millisecond {
+				interval = time.Millisecond
+			}
+			d.scheduler.SetInterval("sample", interval)
+		}
+	}
+
+	for i, v := range m.values() {
+		fmt.Printf("%s: %s %s\n", metrics[i].Name, metrics[i].Format(*v), metrics[i].Unit)
+	}
+
+	if d.recorder != nil {
+		err = d.recorder.Write(m)
+		if err != nil {
+			fmt.Printf("record error=%v\n", err)
+		}
+	}
+	if d.store != nil {
+		err = d.store.Append(m)
+		if err != nil {
+			fmt.Printf("store error=%v\n", err)
+		}
+	}
+	d.history.Add(m)
+
+	if s, ok := d.sensor.(StatusSensor); ok {
+		status, err := s.Status()
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			return
+		}
+		d.mu.Lock()
+		d.status = &status
+		d.mu.Unlock()
+	}
+}
+
+// publish sends the latest measurement to home assistant
+//
+// In JSON state mode each document carries the time it was measured, so
+// while the broker is away measurements are queued, and once it returns
+// the queue is published, oldest first, before the latest measurement.
+// Each topic only has a bare value, so nothing is queued in topics mode.
+func (d *Daemon) publish() {
+	m, ok := d.history.Latest()
+	if !ok {
+		return
+	}
+	queueing := options.HomeAssistant.State == "json"
+
+	if d.client.IsConnectionOpen() && queueing {
+		n := d.queue.Len()
+		err := d.queue.Drain(func(m Measurement) error {
+			return d.publishMeasurement(m, nil)
+		})
+		if err != nil {
+			fmt.Printf("mqtt error=%v\n", err)
+		} else if n > 0 {
+			fmt.Printf("Published %d queued measurements\n", n)
+		}
+	}
+	if m.Time.Equal(d.handled) {
+		return
+	}
+
+	var err error
+	if d.client.IsConnectionOpen() && d.queue.Len() == 0 {
+		d.mu.Lock()
+		status := d.status
+		d.mu.Unlock()
+		err = d.publishMeasurement(m, status)
+		if err == nil {
+			d.handled = m.Time
+			return
+		}
+		fmt.Printf("mqtt error=%v\n", err)
+	}
+
+	d.handled = m.Time
+	if !queueing {
+		fmt.Printf("Measurement not published, only ha.state: json queues measurements until the broker returns\n")
+		return
+	}
+	err = d.queue.Push(m)
+	if err != nil {
+		fmt.Printf("queue error=%v\n", err)
+	}
+}
+
+// publishMeasurement publishes a measurement's state, with the sensor
+// status if it is known
+func (d *Daemon) publishMeasurement(m Measurement, status *uint32) error {
+	if options.HomeAssistant.State == "json" {
+		state := m.jsonFields()
+		if status != nil {
+			state["status"] = *status
+		}
+		payload, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+		return publishToken(d.client.Publish(jsonStateTopic(), 0, false, payload))
+	}
+
+	for i, v := range m.values() {
+		err := publishToken(d.client.Publish(stateTopic(&metrics[i]), 0, false, metrics[i].Format(*v)))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderedChart is the latest chart rendered for a metric
+type renderedChart struct {
+	day  time.Time
+	path string
+}
+
+// render draws today's charts into today's directory under the work
+// directory
+//
+// A chart is only recorded once it has rendered, so an upload never
+// publishes one day's chart under another day's name.
+func (d *Daemon) render() {
+	m, ok := d.history.Latest()
+	if !ok {
+		return
+	}
+	day := startOfDay(m.Time)
+	dir := filepath.Join(d.workdir, day.Format(segmentDateFormat))
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		fmt.Printf("render error=%v\n", err)
+		return
+	}
+
+	for i := range metrics {
+		path := filepath.Join(dir, metrics[i].Key+".png")
+		if !d.chart(&metrics[i], path) {
+			continue
+		}
+		d.mu.Lock()
+		if d.charts == nil {
+			d.charts = map[string]renderedChart{}
+		}
+		d.charts[metrics[i].Key] = renderedChart{day: day, path: path}
+		d.mu.Unlock()
+	}
+
+	// keep yesterday's charts for an upload that may still be reading them
+	//
+	d.removeChartsBefore(day.AddDate(0, 0, -1))
+}
+
+func (d *Daemon) chart(metric *Metric, path string) bool {
+	xaxis, values := d.history.Series(metric.Key)
+	err := renderChart(path, metric, xaxis, values)
+	if err != nil {
+		fmt.Printf("render error=%v\n", err)
+		return false
+	}
+	return true
+}
+
+// removeChartsBefore removes the chart directories for days before day
+func (d *Daemon) removeChartsBefore(day time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	dirs, err := filepath.Glob(filepath.Join(d.workdir, "????-??-??"))
+	if err != nil {
+		return
+	}
+	for _, dir := range dirs {
+		dirDay, err := time.ParseInLocation(segmentDateFormat, filepath.Base(dir), time.Local)
+		if err != nil || !dirDay.Before(day) {
+			continue
+		}
+		for key, chart := range d.charts {
+			if chart.day.Equal(dirDay) {
+				delete(d.charts, key)
+			}
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// upload publishes the rendered charts under their dated names, with
+// <metric>-today.png referring to the latest, and then the dashboard
+func (d *Daemon) upload() {
+	if d.publisher == nil {
+		return
+	}
+
+	// render may move on to a new day while this runs, so take the charts
+	// rendered so far
+	//
+	d.mu.Lock()
+	charts := make([]renderedChart, len(metrics))
+	for i := range metrics {
+		charts[i] = d.charts[metrics[i].Key]
+	}
+	d.mu.Unlock()
+
+	// the dashboard is for the latest day with a chart
+	//
+	var day time.Time
+	var published []*Metric
+	for i, chart := range charts {
+		if chart.path == "" {
+			continue
+		}
+		name := metrics[i].Key
+		dated := datedName(name, ".png", chart.day)
+		err := d.publisher.Publish(chart.path, dated)
+		if err != nil {
+			fmt.Printf("upload error=%v\n", err)
+			continue
+		}
+		if chart.day.After(day) {
+			day = chart.day
+			published = nil
+		}
+		if chart.day.Equal(day) {
+			published = append(published, &metrics[i])
+		}
+		err = d.publisher.Alias(name+"-today.png", dated)
+		if err != nil {
+			fmt.Printf("upload error=%v\n", err)
+		}
+	}
+
+	if !options.Upload.NoDashboard && len(published) > 0 {
+		err := d.uploadDashboard(day, published)
+		if err != nil {
+			fmt.Printf("upload error=%v\n", err)
+		}
+	}
+}
+
+// uploadDashboard publishes index.html with today's charts and the day's
+// own page, which stays behind as its archive
+//
+// Both link to the pages of the other days in the history store and any
+// published since we started.
+func (d *Daemon) uploadDashboard(day time.Time, published []*Metric) error {
+	m, ok := d.history.Latest()
+	if !ok {
+		return nil
+	}
+	day = startOfDay(day)
+	if d.pageDays == nil {
+		d.pageDays = map[string]time.Time{}
+	}
+	d.pageDays[day.Format(segmentDateFormat)] = day
+	days := d.dashboardDays(day)
+
+	page := datedName("index", ".html", day)
+	dashboard := NewDashboard(day, m)
+	dashboard.Home = relativeLink(page, "index.html")
+	for _, metric := range published {
+		dashboard.Charts = append(dashboard.Charts, DashboardChart{Name: metric.Name, Src: relativeLink(page, datedName(metric.Key, ".png", day))})
+	}
+	for _, other := range days {
+		dashboard.Days = append(dashboard.Days, DashboardDay{Day: other, Href: relativeLink(page, datedName("index", ".html", other))})
+	}
+	path := filepath.Join(d.workdir, "day.html")
+	err := dashboard.WriteFile(path)
+	if err != nil {
+		return err
+	}
+	err = d.publisher.Publish(path, page)
+	if err != nil {
+		return err
+	}
+
+	dashboard.Home = ""
+	dashboard.Refresh = int(options.Interval.Upload / time.Second)
+	for i, metric := range published {
+		dashboard.Charts[i].Src = metric.Key + "-today.png"
+	}
+	for i := range dashboard.Days {
+		dashboard.Days[i].Href = datedName("index", ".html", dashboard.Days[i].Day)
+	}
+	path = filepath.Join(d.workdir, "index.html")
+	err = dashboard.WriteFile(path)
+	if err != nil {
+		return err
+	}
+	return d.publisher.Publish(path, "index.html")
+}
+
+// dashboardDays returns the days other than day with a dashboard page,
+// newest first
+func (d *Daemon) dashboardDays(day time.Time) []time.Time {
+	seen := map[string]bool{day.Format(segmentDateFormat): true}
+	var days []time.Time
+	for key, other := range d.pageDays {
+		if !seen[key] {
+			seen[key] = true
+			days = append(days, other)
+		}
+	}
+	if d.store != nil {
+		stored, err := d.store.Days()
+		if err != nil {
+			fmt.Printf("store error=%v\n", err)
+		}
+		for _, other := range stored {
+			key := other.Format(segmentDateFormat)
+			if !seen[key] && other.Before(day) {
+				seen[key] = true
+				days = append(days, other)
+			}
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].After(days[j]) })
+	return days
+}
+
+// datedName is what a file is published as for a day, following the
+// upload layout
+func datedName(name string, ext string, day time.Time) string {
+	if options.Upload.Layout == "dated" {
+		return day.Format("2006-01-02") + "/" + name + ext
+	}
+	return name + "-" + day.Format("2006-01-02") + ext
+}
+
+// stopSensor stops the sensor, unless that has already been started
+func (d *Daemon) stopSensor() {
+	if !atomic.CompareAndSwapUint32(&d.sensorStopped, 0, 1) {
+		return
+	}
+	err := d.sensor.Stop()
+	if err != nil {
+		fmt.Printf("%v\n", err)
+	} else {
+		fmt.Printf("Measurements stopped\n")
+	}
+}
+
+// shutdown stops the sensor, flushes the history and tells home assistant
+// we have gone
+//
+// Sample and publish must have finished, but an upload may still be
+// running and is abandoned.
+func (d *Daemon) shutdown() {
+	fmt.Printf("Shutting down\n")
+
+	if d.server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		err := d.server.Shutdown(ctx)
+		cancel()
+		if err != nil {
+			fmt.Printf("http error=%v\n", err)
+		}
+	}
+
+	d.stopSensor()
+
+	if d.store != nil {
+		err := d.store.Close()
+		if err != nil {
+			fmt.Printf("store error=%v\n", err)
+		}
+	}
+	if d.recorder != nil {
+		err := d.recorder.Close()
+		if err != nil {
+			fmt.Printf("record error=%v\n", err)
+		}
+	}
+
+	if d.client.IsConnectionOpen() {
+		token := d.client.Publish(availabilityTopic(), 0, true, "offline")
+		if !token.WaitTimeout(2 * time.Second) {
+			fmt.Printf("mqtt error=timed out publishing offline\n")
+		} else if token.Error() != nil {
+			fmt.Printf("mqtt error=%v\n", token.Error())
+		}
+	}
+	d.client.Disconnect(250)
+
+	if d.publisher != nil {
+		err := d.publisher.Close()
+		if err != nil {
+			fmt.Printf("upload error=%v\n", err)
+		}
+	}
+
+	os.RemoveAll(d.workdir)
+}
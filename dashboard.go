@@ -0,0 +1,112 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/wcharczuk/go-chart/v2/drawing"
+)
+
+//go:embed dashboard.html
+var dashboardHTML string
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(dashboardHTML))
+
+// Dashboard is a page of one day's charts with the latest readings
+type Dashboard struct {
+	Title    string
+	Day      time.Time
+	Updated  time.Time
+	Refresh  int    // seconds between reloads, 0 for none
+	Home     string // link to today's page, empty on it
+	Readings []DashboardReading
+	Charts   []DashboardChart
+	Days     []DashboardDay // other days, newest first
+}
+
+type DashboardReading struct {
+	Name  string
+	Value string
+	Unit  string
+	Color string
+}
+
+type DashboardChart struct {
+	Name string
+	Src  string
+}
+
+type DashboardDay struct {
+	Day  time.Time
+	Href string
+}
+
+// NewDashboard returns a dashboard for day showing the readings in m
+func NewDashboard(day time.Time, m Measurement) *Dashboard {
+	dashboard := &Dashboard{
+		Title:   options.HomeAssistant.DeviceName,
+		Day:     day,
+		Updated: m.Time,
+	}
+	for i, v := range m.values() {
+		metric := &metrics[i]
+		reading := DashboardReading{Name: metric.Name, Value: "-", Unit: metric.Unit, Color: "#cccccc"}
+		if !math.IsNaN(*v) {
+			reading.Value = metric.Format(*v)
+			reading.Color = hexColor(metric.Color(*v))
+		}
+		dashboard.Readings = append(dashboard.Readings, reading)
+	}
+	return dashboard
+}
+
+// Render writes the dashboard as HTML
+func (dashboard *Dashboard) Render(w io.Writer) error {
+	return dashboardTemplate.Execute(w, dashboard)
+}
+
+// WriteFile renders the dashboard to a temporary file and renames it into
+// place, like the charts
+func (dashboard *Dashboard) WriteFile(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".dashboard*.html")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	err = dashboard.Render(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(f.Name(), 0666)
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// hexColor returns a colour as #rrggbb for CSS
+func hexColor(c drawing.Color) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// relativeLink returns the link from the published page from to the
+// published file to
+func relativeLink(from string, to string) string {
+	if path.Dir(from) == path.Dir(to) {
+		return path.Base(to)
+	}
+	return strings.Repeat("../", strings.Count(from, "/")) + to
+}
@@ -2,16 +2,21 @@ module github.com/plord12/airquality
 
 go 1.17
 
-require github.com/wcharczuk/go-chart/v2 v2.1.0
+require (
+	github.com/eclipse/paho.mqtt.golang v1.5.0
+	github.com/jessevdk/go-flags v1.6.1
+	github.com/pkg/sftp v1.13.7
+	github.com/wcharczuk/go-chart/v2 v2.1.0
+	golang.org/x/crypto v0.25.0
+	golang.org/x/sys v0.22.0
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
-	github.com/eclipse/paho.mqtt.golang v1.5.0 // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
 	github.com/gorilla/websocket v1.5.3 // indirect
-	github.com/jessevdk/go-flags v1.6.1 // indirect
-	github.com/wcharczuk/go-chart v2.0.1+incompatible // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	golang.org/x/image v0.0.0-20200927104501-e162460cd6b5 // indirect
 	golang.org/x/net v0.27.0 // indirect
 	golang.org/x/sync v0.7.0 // indirect
-	golang.org/x/sys v0.22.0 // indirect
 )
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// History is today's measurements, kept in memory for the charts
+type History struct {
+	mu           sync.Mutex
+	measurements []Measurement
+}
+
+// Add appends a measurement, starting afresh when the day changes
+func (h *History) Add(m Measurement) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.measurements) > 0 && !startOfDay(h.measurements[len(h.measurements)-1].Time).Equal(startOfDay(m.Time)) {
+		h.measurements = h.measurements[:0]
+	}
+	h.measurements = append(h.measurements, m)
+}
+
+// Latest returns the most recent measurement
+func (h *History) Latest() (Measurement, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.measurements) == 0 {
+		return Measurement{}, false
+	}
+	return h.measurements[len(h.measurements)-1], true
+}
+
+// Series returns copies of the times and values of one metric, with
+// missing values as 0 so they can be charted
+func (h *History) Series(metric string) ([]time.Time, []float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	xaxis := make([]time.Time, 0, len(h.measurements))
+	values := make([]float64, 0, len(h.measurements))
+	for _, m := range h.measurements {
+		v, _ := m.Value(metric)
+		if math.IsNaN(v) {
+			v = 0
+		}
+		xaxis = append(xaxis, m.Time)
+		values = append(values, v)
+	}
+	return xaxis, values
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// HookPublisher publishes by running a shell command
+//
+// The command is run once for each file with
+//
+//	AIRQUALITY_ACTION=publish
+//	AIRQUALITY_FILE   the local file
+//	AIRQUALITY_NAME   the name to publish it as
+//
+// and once for each alias with
+//
+//	AIRQUALITY_ACTION=alias
+//	AIRQUALITY_NAME   the published name
+//	AIRQUALITY_ALIAS  the alias to make refer to it
+//
+// in its environment.  A non-zero exit status is an error.
+type HookPublisher struct {
+	command string
+}
+
+// NewHookPublisher returns a publisher that runs command
+func NewHookPublisher(command string) *HookPublisher {
+	return &HookPublisher{command: command}
+}
+
+func (p *HookPublisher) Publish(path string, name string) error {
+	return p.run("AIRQUALITY_ACTION=publish", "AIRQUALITY_FILE="+path, "AIRQUALITY_NAME="+name)
+}
+
+func (p *HookPublisher) Alias(alias string, name string) error {
+	return p.run("AIRQUALITY_ACTION=alias", "AIRQUALITY_NAME="+name, "AIRQUALITY_ALIAS="+alias)
+}
+
+func (p *HookPublisher) run(env ...string) error {
+	cmd := exec.Command("/bin/sh", "-c", p.command)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return commandError(p.command, err, output)
+	}
+	return nil
+}
+
+func (p *HookPublisher) Close() error {
+	return nil
+}
+
+// commandError is a failed command's error with any output it wrote
+func commandError(name string, err error, output []byte) error {
+	if message := strings.TrimSpace(string(output)); message != "" {
+		return fmt.Errorf("%s: %v: %s", name, err, message)
+	}
+	return fmt.Errorf("%s: %v", name, err)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Bus is a connection to a single device on an I2C bus
+//
+// Each Write and Read is one complete I2C transfer.
+type Bus interface {
+	Write(data []byte) error
+	Read(data []byte) error
+	Close() error
+}
+
+// from linux/i2c-dev.h
+const i2cSlave = 0x0703
+
+// I2CDevice is a device on a Linux /dev/i2c-N bus
+type I2CDevice struct {
+	file *os.File
+}
+
+// OpenI2C opens the I2C bus device and selects the device address
+func OpenI2C(device string, address uint16) (*I2CDevice, error) {
+	f, err := os.OpenFile(device, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = unix.IoctlSetInt(int(f.Fd()), i2cSlave, int(address))
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: set address 0x%02x: %v", device, address, err)
+	}
+	return &I2CDevice{file: f}, nil
+}
+
+func (d *I2CDevice) Write(data []byte) error {
+	n, err := d.file.Write(data)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return fmt.Errorf("i2c short write %d of %d bytes", n, len(data))
+	}
+	return nil
+}
+
+func (d *I2CDevice) Read(data []byte) error {
+	n, err := d.file.Read(data)
+	if err != nil {
+		return err
+	}
+	if n != len(data) {
+		return fmt.Errorf("i2c short read %d of %d bytes", n, len(data))
+	}
+	return nil
+}
+
+func (d *I2CDevice) Close() error {
+	return d.file.Close()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// LocalPublisher publishes into a local directory, typically a web root
+// when we run on the web server itself
+//
+// Files are copied to a temporary file and renamed into place, and aliases
+// are symlinks replaced the same way, so the web server never serves a
+// half written chart.
+type LocalPublisher struct {
+	dir string
+}
+
+// NewLocalPublisher returns a publisher that writes into dir
+func NewLocalPublisher(dir string) *LocalPublisher {
+	return &LocalPublisher{dir: dir}
+}
+
+func (p *LocalPublisher) Publish(path string, name string) error {
+	dst := filepath.Join(p.dir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(dst), 0755)
+	if err != nil {
+		return err
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	f, err := os.CreateTemp(filepath.Dir(dst), ".publish*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = io.Copy(f, src)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Chmod(f.Name(), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dst)
+}
+
+func (p *LocalPublisher) Alias(alias string, name string) error {
+	link := filepath.Join(p.dir, filepath.FromSlash(alias))
+	target, err := filepath.Rel(filepath.Dir(link), filepath.Join(p.dir, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+
+	// symlink to a temporary name, as symlink won't replace an existing
+	// link, and rename that over the alias
+	//
+	f, err := os.CreateTemp(filepath.Dir(link), ".alias*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	os.Remove(f.Name())
+	err = os.Symlink(target, f.Name())
+	if err != nil {
+		return err
+	}
+	err = os.Rename(f.Name(), link)
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (p *LocalPublisher) Close() error {
+	return nil
+}
@@ -1,702 +1,315 @@
 package main
 
-// #cgo CFLAGS: -g -Wall
-// #include <stdlib.h>
-// #include "sen5x_i2c.h"
-// #include "sensirion_common.h"
-// #include "sensirion_i2c_hal.h"
-import "C"
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"log"
-	"math"
 	"os"
-	"os/exec"
+	"os/signal"
+	"syscall"
 	"time"
-	"unsafe"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/jessevdk/go-flags"
-	"github.com/wcharczuk/go-chart/v2"
-	"github.com/wcharczuk/go-chart/v2/drawing"
 )
 
 type Options struct {
-	Broker    string `short:"b" long:"broker" description:"MQTT broker address" required:"true"`
-	Username  string `short:"u" long:"username" description:"MQTT broker username" required:"true"`
-	Password  string `short:"p" long:"password" description:"MQTT broker password" required:"true"`
-	BaseTopic string `short:"t" long:"basetopic" description:"MQTT base topic" default:"homeassistant/sensor/airquality"`
+	Config          string               `short:"c" long:"config" description:"YAML configuration file, command line options override it"`
+	Broker          string               `short:"b" long:"broker" description:"MQTT broker address" env:"AIRQUALITY_BROKER"`
+	Username        string               `short:"u" long:"username" description:"MQTT broker username" env:"AIRQUALITY_USERNAME"`
+	Password        string               `short:"p" long:"password" description:"MQTT broker password, visible to other users so prefer the environment or --credentials" env:"AIRQUALITY_PASSWORD"`
+	Credentials     string               `long:"credentials" description:"YAML file with the broker username and password, only readable by its owner" env:"AIRQUALITY_CREDENTIALS"`
+	BaseTopic       string               `short:"t" long:"basetopic" description:"MQTT base topic" default:"homeassistant/sensor/airquality"`
+	ClientID        string               `long:"client-id" description:"MQTT client ID, must be unique on the broker" default:"airquality"`
+	Sensor          string               `long:"sensor" description:"Sensor to read" choice:"sen5x" choice:"sim" choice:"replay" default:"sen5x"`
+	Seed            int64                `long:"seed" description:"Random seed for the simulated sensor" default:"1"`
+	Record          string               `long:"record" description:"Record every measurement to this file (CSV if it ends in .csv, otherwise JSON lines)"`
+	ReplayFile      string               `long:"replay-file" description:"Recorded measurements to replay with --sensor=replay"`
+	ReplaySpeed     float64              `long:"replay-speed" description:"Replay speed, 1 is real time" default:"1"`
+	ShutdownTimeout time.Duration        `long:"shutdown-timeout" description:"Longest to wait for a clean shutdown" default:"10s"`
+	TLS             TLSOptions           `group:"MQTT TLS Options" namespace:"tls"`
+	HomeAssistant   HomeAssistantOptions `group:"Home Assistant Options" namespace:"ha"`
+	I2C             I2COptions           `group:"I2C Options" namespace:"i2c"`
+	Store           StoreOptions         `group:"History Store Options" namespace:"store"`
+	Interval        IntervalOptions      `group:"Schedule Options" namespace:"interval"`
+	Queue           QueueOptions         `group:"Offline Queue Options" namespace:"queue"`
+	Upload          UploadOptions        `group:"Upload Options" namespace:"upload"`
+	Thresholds      ThresholdOptions     `group:"Chart Threshold Options" namespace:"thresholds"`
+	HTTP            HTTPOptions          `group:"Dashboard Server Options" namespace:"http"`
+
+	ConfigCommand ConfigCommand `command:"config" description:"Configuration commands"`
+}
+
+type TLSOptions struct {
+	CA                 string `long:"ca" description:"CA bundle to verify the broker with, the system roots are used if not set"`
+	Cert               string `long:"cert" description:"Client certificate to present to the broker"`
+	Key                string `long:"key" description:"Private key for the client certificate"`
+	ServerName         string `long:"server-name" description:"Name to verify the broker certificate against, defaults to the broker host"`
+	InsecureSkipVerify bool   `long:"insecure-skip-verify" description:"Do not verify the broker certificate"`
+}
+
+type HomeAssistantOptions struct {
+	DeviceName  string        `long:"device-name" description:"Name of the device in home assistant" default:"Air Quality"`
+	Area        string        `long:"area" description:"Suggested home assistant area for the device"`
+	State       string        `long:"state" description:"Publish each reading on its own topic, or all readings as one JSON document" choice:"topics" choice:"json" default:"topics"`
+	ExpireAfter time.Duration `long:"expire-after" description:"How long home assistant keeps a reading before marking it unavailable, defaults to three publish intervals"`
+}
+
+type I2COptions struct {
+	Device  string `long:"device" description:"I2C bus device" default:"/dev/i2c-0"`
+	Address uint16 `long:"address" description:"I2C address of the sensor" base:"0" default:"0x69"`
+}
+
+type StoreOptions struct {
+	Dir             string        `long:"dir" description:"Directory to keep every measurement in, history is only kept in memory if not set or when replaying"`
+	RawDays         int           `long:"raw-days" description:"Days to keep every sample before downsampling, 0 keeps them forever" default:"30"`
+	Aggregate       time.Duration `long:"aggregate" description:"Bucket size samples are downsampled to" default:"5m"`
+	AggregateDays   int           `long:"aggregate-days" description:"Days to keep downsampled history before reducing it to hourly, 0 keeps it forever" default:"365"`
+	HourlyDays      int           `long:"hourly-days" description:"Days to keep hourly history, 0 keeps it forever" default:"0"`
+	CompactInterval time.Duration `long:"compact-interval" description:"How often to enforce the retention limits" default:"1h"`
+}
+
+type IntervalOptions struct {
+	Sample  time.Duration `long:"sample" description:"How often to read the sensor, replays follow the recorded times" default:"1m"`
+	Publish time.Duration `long:"publish" description:"How often to publish to MQTT" default:"1m"`
+	Render  time.Duration `long:"render" description:"How often to render the charts" default:"1m"`
+	Upload  time.Duration `long:"upload" description:"How often to upload the charts" default:"1m"`
+}
+
+type QueueOptions struct {
+	File string `long:"file" description:"File to keep unpublished measurements in while the broker is away with ha.state: json, they are only kept in memory if not set"`
+	Size int    `long:"size" description:"Most unpublished measurements to keep, the oldest are dropped" default:"1440"`
+}
+
+type UploadOptions struct {
+	Publisher    string   `long:"publisher" description:"How to publish the charts" choice:"none" choice:"local" choice:"sftp" choice:"s3" choice:"webdav" choice:"command" default:"sftp"`
+	Layout       string   `long:"layout" description:"Name the day's charts <metric>-<date>.png, or <date>/<metric>.png" choice:"flat" choice:"dated" default:"flat"`
+	Host         string   `long:"host" description:"Host to upload the charts to with sftp, with an optional :port" default:"arm3"`
+	User         string   `long:"user" description:"User to log in to the sftp host or WebDAV server as, defaults to the user we run as for sftp"`
+	Password     string   `long:"password" description:"WebDAV password" env:"AIRQUALITY_UPLOAD_PASSWORD"`
+	Key          []string `long:"key" description:"Private key to log in to the sftp host with, defaults to the usual keys in ~/.ssh, an ssh agent is also used if running"`
+	KnownHosts   string   `long:"known-hosts" description:"Known hosts file the sftp host key must be in, defaults to ~/.ssh/known_hosts"`
+	Dir          string   `long:"dir" description:"Directory to publish the charts into, on the host for sftp" default:"/var/www/html/airquality"`
+	URL          string   `long:"url" description:"WebDAV collection to upload the charts into, eg https://cloud.example.com/remote.php/dav/files/me/airquality"`
+	Endpoint     string   `long:"endpoint" description:"S3 endpoint URL, eg https://s3.eu-west-2.amazonaws.com or http://localhost:9000 for MinIO"`
+	Bucket       string   `long:"bucket" description:"S3 bucket to upload the charts to"`
+	Region       string   `long:"region" description:"S3 region" default:"us-east-1"`
+	Prefix       string   `long:"prefix" description:"S3 key prefix, like a directory"`
+	AccessKey    string   `long:"access-key" description:"S3 access key" env:"AWS_ACCESS_KEY_ID"`
+	SecretKey    string   `long:"secret-key" description:"S3 secret key" env:"AWS_SECRET_ACCESS_KEY"`
+	CacheControl string   `long:"cache-control" description:"Cache-Control for uploaded S3 objects" default:"max-age=60"`
+	NoDashboard  bool     `long:"no-dashboard" description:"Do not publish index.html and a page for each day showing the charts"`
+	Command      string   `long:"command" description:"Shell command run for each chart and alias, with AIRQUALITY_ACTION, AIRQUALITY_FILE, AIRQUALITY_NAME and AIRQUALITY_ALIAS in its environment"`
+}
+
+type HTTPOptions struct {
+	Listen  string        `long:"listen" description:"Address to serve a live dashboard on, eg :8080, it is not served if not set"`
+	Refresh time.Duration `long:"refresh" description:"How often the dashboard reloads itself, 0 never" default:"1m"`
+}
+
+// ThresholdOptions has an option for each metric with chart thresholds,
+// named after the metric key, with the metrics table as the defaults
+//
+// newParser adds the options from the metrics table.
+type ThresholdOptions struct {
+	values map[string][]float64
+}
+
+// Get returns a metric's thresholds
+func (t ThresholdOptions) Get(key string) []float64 {
+	return t.values[key]
 }
 
 var options Options
-var parser = flags.NewParser(&options, flags.Default)
+var parser = newParser(&options, flags.Default)
 
 func main() {
-	// parse flags
+	// parse config file and flags
 	//
-	_, err := parser.Parse()
+	err := loadConfig(parser, os.Args[1:])
 	if err != nil {
+		fmt.Printf("config error=%v\n", err)
+		os.Exit(1)
+	}
+	err = loadCredentials(parser, os.Args[1:])
+	if err != nil {
+		fmt.Printf("credentials error=%v\n", err)
+		os.Exit(1)
+	}
+	_, err = parser.Parse()
+	if err != nil {
+		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
+			os.Exit(0)
+		}
+		os.Exit(1)
+	}
+	if parser.Active != nil {
+		// a command such as config check has run
 		os.Exit(0)
 	}
-
-	//mqtt.DEBUG = log.New(os.Stdout, "", 0)
-	mqtt.ERROR = log.New(os.Stdout, "", 0)
-	opts := mqtt.NewClientOptions().AddBroker(options.Broker).SetClientID("airquality")
-	opts.SetKeepAlive(2 * time.Second)
-	opts.SetPingTimeout(1 * time.Second)
-	opts = opts.SetUsername(options.Username)
-	opts = opts.SetPassword(options.Password)
-	c := mqtt.NewClient(opts)
-	if token := c.Connect(); token.Wait() && token.Error() != nil {
-		panic(token.Error())
+	err = checkOptions(&options)
+	if err != nil {
+		fmt.Printf("config error=%v\n", err)
+		os.Exit(1)
 	}
+	applyThresholds(&options)
 
-	// create MQTT configs
-	config, _ := json.Marshal(map[string]string{
-		"name":                "Air Quality PM1.0",
-		"unique_id":           "air_quality_pm1p0",
-		"object_id":           "air_quality_pm1p0",
-		"unit_of_measurement": "µg/m³",
-		"device_class":        "pm1",
-		"state_topic":         options.BaseTopic + "/air_quality_pm1p0/state",
-		"value_template":      "{{ value | float(0) }}",
-	})
-	c.Publish(options.BaseTopic+"/air_quality_pm1p0/config", 0, true, config).Wait()
-	config, _ = json.Marshal(map[string]string{
-		"name":                "Air Quality PM2.5",
-		"unique_id":           "air_quality_pm2p5",
-		"object_id":           "air_quality_pm2p5",
-		"unit_of_measurement": "µg/m³",
-		"device_class":        "pm25",
-		"state_topic":         options.BaseTopic + "/air_quality_pm2p5/state",
-		"value_template":      "{{ value | float(0) }}",
-	})
-	c.Publish(options.BaseTopic+"/air_quality_pm2p5/config", 0, true, config).Wait()
-	config, _ = json.Marshal(map[string]string{
-		"name":                "Air Quality PM4.0",
-		"unique_id":           "air_quality_pm4p0",
-		"object_id":           "air_quality_pm4p0",
-		"unit_of_measurement": "µg/m³",
-		"device_class":        "pm25",
-		"state_topic":         options.BaseTopic + "/air_quality_pm4p0/state",
-		"value_template":      "{{ value | float(0) }}",
-	})
-	c.Publish(options.BaseTopic+"/air_quality_pm4p0/config", 0, true, config).Wait()
-	config, _ = json.Marshal(map[string]string{
-		"name":                "Air Quality PM10",
-		"unique_id":           "air_quality_pm10p0",
-		"object_id":           "air_quality_pm10p0",
-		"unit_of_measurement": "µg/m³",
-		"device_class":        "pm10",
-		"state_topic":         options.BaseTopic + "/air_quality_pm10p0/state",
-		"value_template":      "{{ value | float(0) }}",
-	})
-	c.Publish(options.BaseTopic+"/air_quality_pm10p0/config", 0, true, config).Wait()
-	config, _ = json.Marshal(map[string]string{
-		"name":                "Air Quality Humidity",
-		"unique_id":           "air_quality_humidity",
-		"object_id":           "air_quality_humidity",
-		"unit_of_measurement": "%",
-		"device_class":        "humidity",
-		"state_topic":         options.BaseTopic + "/air_quality_humidity/state",
-		"value_template":      "{{ value | float(0) }}",
-	})
-	c.Publish(options.BaseTopic+"/air_quality_humidity/config", 0, true, config).Wait()
-	config, _ = json.Marshal(map[string]string{
-		"name":                "Air Quality Temperature",
-		"unique_id":           "air_quality_temperature",
-		"object_id":           "air_quality_temperature",
-		"unit_of_measurement": "°C",
-		"device_class":        "temperature",
-		"state_topic":         options.BaseTopic + "/air_quality_temperature/state",
-		"value_template":      "{{ value | float(0) }}",
-	})
-	c.Publish(options.BaseTopic+"/air_quality_temperature/config", 0, true, config).Wait()
-	config, _ = json.Marshal(map[string]string{
-		"name":           "Air Quality VOC",
-		"unique_id":      "air_quality_voc",
-		"object_id":      "air_quality_voc",
-		"device_class":   "aqi",
-		"state_topic":    options.BaseTopic + "/air_quality_voc/state",
-		"value_template": "{{ value | float(0) }}",
-	})
-	c.Publish(options.BaseTopic+"/air_quality_voc/config", 0, true, config).Wait()
-	config, _ = json.Marshal(map[string]string{
-		"name":           "Air Quality NOX",
-		"unique_id":      "air_quality_nox",
-		"object_id":      "air_quality_nox",
-		"device_class":   "aqi",
-		"state_topic":    options.BaseTopic + "/air_quality_nox/state",
-		"value_template": "{{ value | float(0) }}",
-	})
-	c.Publish(options.BaseTopic+"/air_quality_nox/config", 0, true, config).Wait()
-
-	C.sensirion_i2c_hal_init(C.CString("/dev/i2c-0"))
-
-	error := C.sen5x_device_reset()
-	if error == -1 {
-		fmt.Printf("sen5x_device_reset error=%v\n", error)
+	sensor, err := newSensor()
+	if err != nil {
+		fmt.Printf("sensor error=%v\n", err)
+		os.Exit(1)
 	}
 
-	serial_number := C.malloc(C.sizeof_char * 32)
-	defer C.free(unsafe.Pointer(serial_number))
-	serial_number_size := C.uchar(32)
-	error = C.sen5x_get_serial_number((*C.uchar)(serial_number), serial_number_size)
-	if error == -1 {
-		fmt.Printf("sen5x_get_serial_number error=%v\n", error)
-	} else {
-		b := C.GoBytes(serial_number, (C.int)(serial_number_size))
-		fmt.Printf("Serial number %s\n", b)
+	err = sensor.Init()
+	if err != nil {
+		fmt.Printf("%v\n", err)
 	}
 
-	product_name := C.malloc(C.sizeof_char * 32)
-	defer C.free(unsafe.Pointer(product_name))
-	product_name_size := C.uchar(32)
-	error = C.sen5x_get_product_name((*C.uchar)(product_name), product_name_size)
-	if error == -1 {
-		fmt.Printf("sen5x_get_product_name error=%v\n", error)
+	info, err := sensor.Info()
+	if err != nil {
+		fmt.Printf("%v\n", err)
 	} else {
-		b := C.GoBytes(product_name, (C.int)(product_name_size))
-		fmt.Printf("Product name %s\n", b)
+		fmt.Printf("Serial number %s\n", info.SerialNumber)
+		fmt.Printf("Product name %s\n", info.ProductName)
+		fmt.Printf("Firmware version %s\n", info.FirmwareVersion)
 	}
 
-	error = C.sen5x_start_measurement()
-	if error == -1 {
-		fmt.Printf("sen5x_start_measurement error=%v\n", error)
+	err = sensor.Start()
+	if err != nil {
+		fmt.Printf("%v\n", err)
 	} else {
 		fmt.Printf("Measuments started\n")
 	}
 
-	mass_concentration_pm1p0 := C.float(0.0)
-	mass_concentration_pm2p5 := C.float(0.0)
-	mass_concentration_pm4p0 := C.float(0.0)
-	mass_concentration_pm10p0 := C.float(0.0)
-	ambient_humidity := C.float(0.0)
-	ambient_temperature := C.float(0.0)
-	voc_index := C.float(0.0)
-	nox_index := C.float(0.0)
-
 	time.Sleep(1 * time.Second)
 
-	var xaxis []time.Time
-	var pm1p0 []float64
-	var pm2p5 []float64
-	var pm4p0 []float64
-	var pm10p0 []float64
-	var humidity []float64
-	var temperature []float64
-	var voc []float64
-	var nox []float64
-	lastHour := 0
-
-	// loop forever
-
-	for {
-		error = C.sen5x_read_measured_values(
-			&mass_concentration_pm1p0, &mass_concentration_pm2p5,
-			&mass_concentration_pm4p0, &mass_concentration_pm10p0,
-			&ambient_humidity, &ambient_temperature, &voc_index, &nox_index)
-		if error == -1 {
-			fmt.Printf("sen5x_read_measured_values error=%v\n", error)
-		} else {
-			fmt.Printf("Mass concentration pm1p0: %.1f µg/m³\n", mass_concentration_pm1p0)
-			fmt.Printf("Mass concentration pm2p5: %.1f µg/m³\n", mass_concentration_pm2p5)
-			fmt.Printf("Mass concentration pm4p0: %.1f µg/m³\n", mass_concentration_pm4p0)
-			fmt.Printf("Mass concentration pm10p0: %.1f µg/m³\n", mass_concentration_pm10p0)
-			fmt.Printf("Ambient humidity: %.1f %%RH\n", ambient_humidity)
-			fmt.Printf("Ambient temperature: %.1f °C\n", ambient_temperature)
-			fmt.Printf("Voc index: %.1f\n", voc_index)
-			fmt.Printf("Nox index: %.1f\n", nox_index)
-		}
+	c, err := newMQTTClient(info)
+	if err != nil {
+		fmt.Printf("mqtt error=%v\n", err)
+		os.Exit(1)
+	}
+
+	d := &Daemon{sensor: sensor, info: info, client: c, history: &History{}}
+
+	d.publisher, err = newPublisher()
+	if err != nil {
+		fmt.Printf("upload error=%v, charts will not be published\n", err)
+	}
+
+	d.queue, err = OpenQueue(options.Queue.File, options.Queue.Size)
+	if err != nil {
+		fmt.Printf("queue error=%v\n", err)
+		d.queue, _ = OpenQueue("", options.Queue.Size)
+	} else if n := d.queue.Len(); n > 0 {
+		fmt.Printf("Loaded %d unpublished measurements from %s\n", n, options.Queue.File)
+	}
 
-		// call home assistant
-		c.Publish(options.BaseTopic+"/air_quality_pm1p0/state", 0, false, fmt.Sprintf("%.1f", mass_concentration_pm1p0)).Wait()
-		c.Publish(options.BaseTopic+"/air_quality_pm2p5/state", 0, false, fmt.Sprintf("%.1f", mass_concentration_pm2p5)).Wait()
-		c.Publish(options.BaseTopic+"/air_quality_pm4p0/state", 0, false, fmt.Sprintf("%.1f", mass_concentration_pm4p0)).Wait()
-		c.Publish(options.BaseTopic+"/air_quality_pm10p0/state", 0, false, fmt.Sprintf("%.1f", mass_concentration_pm10p0)).Wait()
-		c.Publish(options.BaseTopic+"/air_quality_humidity/state", 0, false, fmt.Sprintf("%.1f", ambient_humidity)).Wait()
-		c.Publish(options.BaseTopic+"/air_quality_temperature/state", 0, false, fmt.Sprintf("%.1f", ambient_temperature)).Wait()
-		c.Publish(options.BaseTopic+"/air_quality_voc/state", 0, false, fmt.Sprintf("%.1f", voc_index)).Wait()
-		c.Publish(options.BaseTopic+"/air_quality_nox/state", 0, false, fmt.Sprintf("%.1f", nox_index)).Wait()
-
-		today := time.Now()
-		if today.Hour() < lastHour {
-			xaxis = xaxis[:0]
-			pm1p0 = pm1p0[:0]
-			pm2p5 = pm2p5[:0]
-			pm4p0 = pm4p0[:0]
-			pm10p0 = pm10p0[:0]
-			humidity = humidity[:0]
-			temperature = temperature[:0]
-			voc = voc[:0]
-			nox = nox[:0]
+	if options.Record != "" {
+		d.recorder, err = NewRecorder(options.Record)
+		if err != nil {
+			fmt.Printf("record error=%v\n", err)
 		}
-		lastHour = today.Hour()
-		xaxis = append(xaxis, today)
-		pm1p0 = append(pm1p0, float64(mass_concentration_pm1p0))
-		pm2p5 = append(pm2p5, float64(mass_concentration_pm2p5))
-		pm4p0 = append(pm4p0, float64(mass_concentration_pm4p0))
-		pm10p0 = append(pm10p0, float64(mass_concentration_pm10p0))
-		humidity = append(humidity, float64(ambient_humidity))
-		temperature = append(temperature, float64(ambient_temperature))
-		voc = append(voc, float64(voc_index))
-		if math.IsNaN(float64(nox_index)) {
-			nox = append(nox, 0)
+	}
+
+	d.workdir, err = os.MkdirTemp("", "airquality")
+	if err != nil {
+		fmt.Printf("workdir error=%v\n", err)
+		os.Exit(1)
+	}
+
+	// stop on SIGINT/SIGTERM, or when a replay finishes
+	//
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d.cancel = cancel
+
+	// reload the config on SIGHUP
+	//
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	// measurements are queued until the broker is reachable
+	//
+	go connectMQTT(ctx, c)
+
+	// reload today's history, unless replaying, as replayed measurements
+	// keep their recorded times and would be mixed into it
+	//
+	if options.Store.Dir != "" && options.Sensor == "replay" {
+		fmt.Printf("Not using the store in %s while replaying\n", options.Store.Dir)
+	} else if options.Store.Dir != "" {
+		store, err := OpenStore(options.Store.Dir, Retention{
+			RawDays:       options.Store.RawDays,
+			Aggregate:     options.Store.Aggregate,
+			AggregateDays: options.Store.AggregateDays,
+			HourlyDays:    options.Store.HourlyDays,
+		})
+		if err != nil {
+			fmt.Printf("store error=%v\n", err)
 		} else {
-			nox = append(nox, float64(nox_index))
+			d.store = store
+			go store.RunCompactor(ctx, options.Store.CompactInterval)
+			history, err := store.Range(startOfDay(time.Now()), time.Now())
+			if err != nil {
+				fmt.Printf("store error=%v\n", err)
+			}
+			for _, h := range history {
+				d.history.Add(h)
+			}
+			fmt.Printf("Loaded %d measurements from %s\n", len(history), options.Store.Dir)
 		}
+	}
 
-		// charts
-		//
-
-		// PM 1.0
-		//
-		graph := chart.Chart{
-			Title:      "PM1.0",
-			Background: chart.Style{Padding: chart.Box{Top: 20, Left: 20, Right: 20, Bottom: 20}},
-			XAxis: chart.XAxis{
-				Style: chart.Style{TextRotationDegrees: 90.0, FontSize: 6},
-				ValueFormatter: func(v interface{}) string {
-					typed := v.(float64)
-					typedDate := chart.TimeFromFloat64(typed)
-					return typedDate.Format("Jan-02-06 15:04")
-				},
-			},
-			YAxis: chart.YAxis{
-				Name:      "µg/m³",
-				NameStyle: chart.Style{FontColor: chart.ColorBlack},
-			},
-			Series: []chart.Series{
-				chart.TimeSeries{
-					YAxis:   chart.YAxisPrimary,
-					XValues: xaxis,
-					YValues: pm1p0,
-					Style: chart.Style{StrokeColor: chart.ColorBlack, DotWidth: 3, DotColorProvider: func(xr, yr chart.Range, index int, x, y float64) drawing.Color {
-						if y < 10 {
-							return drawing.Color{R: 55, G: 172, B: 86, A: 255}
-						}
-						if y < 20 {
-							return drawing.Color{R: 155, G: 212, B: 68, A: 255}
-						}
-						if y < 25 {
-							return drawing.Color{R: 241, G: 210, B: 8, A: 255}
-						}
-						if y < 50 {
-							return drawing.Color{R: 255, G: 187, B: 1, A: 255}
-						}
-						if y < 75 {
-							return drawing.Color{R: 255, G: 140, B: 0, A: 255}
-						}
-						return drawing.Color{R: 237, G: 15, B: 5, A: 255}
-					}},
-				},
-			},
-		}
-		f, _ := os.CreateTemp("", "pm1p0*.png")
-		os.Chmod(f.Name(), 0666)
-		error := graph.Render(chart.PNG, f)
-		if error != nil {
-			fmt.Printf("render error=%v\n", error)
-		}
-		f.Close()
-		cmd := exec.Command("scp", "-p", f.Name(), "arm3:/var/www/html/airquality/pm1p0-"+today.Format("2006-01-02")+".png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("scp error=%v\n", error)
-		}
-		cmd = exec.Command("ssh", "arm3", "ln", "-sf", "/var/www/html/airquality/pm1p0-"+today.Format("2006-01-02")+".png", "/var/www/html/airquality/pm1p0-today.png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("ln error=%v\n", error)
-		}
-		os.Remove(f.Name())
-
-		// PM 2.5
-		//
-		graph = chart.Chart{
-			Title:      "PM2.5",
-			Background: chart.Style{Padding: chart.Box{Top: 20, Left: 20, Right: 20, Bottom: 20}},
-			XAxis: chart.XAxis{
-				Style: chart.Style{TextRotationDegrees: 90.0, FontSize: 6},
-				ValueFormatter: func(v interface{}) string {
-					typed := v.(float64)
-					typedDate := chart.TimeFromFloat64(typed)
-					return typedDate.Format("Jan-02-06 15:04")
-				},
-			},
-			YAxis: chart.YAxis{
-				Name:      "µg/m³",
-				NameStyle: chart.Style{FontColor: chart.ColorBlack},
-			},
-			Series: []chart.Series{
-				chart.TimeSeries{
-					YAxis:   chart.YAxisPrimary,
-					XValues: xaxis,
-					YValues: pm2p5,
-					Style: chart.Style{StrokeColor: chart.ColorBlack, DotWidth: 3, DotColorProvider: func(xr, yr chart.Range, index int, x, y float64) drawing.Color {
-						if y < 10 {
-							return drawing.Color{R: 55, G: 172, B: 86, A: 255}
-						}
-						if y < 20 {
-							return drawing.Color{R: 155, G: 212, B: 68, A: 255}
-						}
-						if y < 25 {
-							return drawing.Color{R: 241, G: 210, B: 8, A: 255}
-						}
-						if y < 50 {
-							return drawing.Color{R: 255, G: 187, B: 1, A: 255}
-						}
-						if y < 75 {
-							return drawing.Color{R: 255, G: 140, B: 0, A: 255}
-						}
-						return drawing.Color{R: 237, G: 15, B: 5, A: 255}
-					}},
-				},
-			},
-		}
-		f, _ = os.CreateTemp("", "pm2p5*.png")
-		os.Chmod(f.Name(), 0666)
-		error = graph.Render(chart.PNG, f)
-		if error != nil {
-			fmt.Printf("render error=%v\n", error)
-		}
-		f.Close()
-		cmd = exec.Command("scp", "-p", f.Name(), "arm3:/var/www/html/airquality/pm2p5-"+today.Format("2006-01-02")+".png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("scp error=%v\n", error)
-		}
-		cmd = exec.Command("ssh", "arm3", "ln", "-sf", "/var/www/html/airquality/pm2p5-"+today.Format("2006-01-02")+".png", "/var/www/html/airquality/pm2p5-today.png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("ln error=%v\n", error)
-		}
-		os.Remove(f.Name())
-
-		// PM 4.0
-		//
-		graph = chart.Chart{
-			Title:      "PM4.0",
-			Background: chart.Style{Padding: chart.Box{Top: 20, Left: 20, Right: 20, Bottom: 20}},
-			XAxis: chart.XAxis{
-				Style: chart.Style{TextRotationDegrees: 90.0, FontSize: 6},
-				ValueFormatter: func(v interface{}) string {
-					typed := v.(float64)
-					typedDate := chart.TimeFromFloat64(typed)
-					return typedDate.Format("Jan-02-06 15:04")
-				},
-			},
-			YAxis: chart.YAxis{
-				Name:      "µg/m³",
-				NameStyle: chart.Style{FontColor: chart.ColorBlack},
-			},
-			Series: []chart.Series{
-				chart.TimeSeries{
-					YAxis:   chart.YAxisPrimary,
-					XValues: xaxis,
-					YValues: pm4p0,
-					Style: chart.Style{StrokeColor: chart.ColorBlack, DotWidth: 3, DotColorProvider: func(xr, yr chart.Range, index int, x, y float64) drawing.Color {
-						if y < 20 {
-							return drawing.Color{R: 55, G: 172, B: 86, A: 255}
-						}
-						if y < 40 {
-							return drawing.Color{R: 155, G: 212, B: 68, A: 255}
-						}
-						if y < 50 {
-							return drawing.Color{R: 241, G: 210, B: 8, A: 255}
-						}
-						if y < 100 {
-							return drawing.Color{R: 255, G: 187, B: 1, A: 255}
-						}
-						if y < 150 {
-							return drawing.Color{R: 255, G: 140, B: 0, A: 255}
-						}
-						return drawing.Color{R: 237, G: 15, B: 5, A: 255}
-					}},
-				},
-			},
-		}
-		f, _ = os.CreateTemp("", "pm4p0*.png")
-		os.Chmod(f.Name(), 0666)
-		error = graph.Render(chart.PNG, f)
-		if error != nil {
-			fmt.Printf("render error=%v\n", error)
-		}
-		f.Close()
-		cmd = exec.Command("scp", "-p", f.Name(), "arm3:/var/www/html/airquality/pm4p0-"+today.Format("2006-01-02")+".png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("scp error=%v\n", error)
-		}
-		cmd = exec.Command("ssh", "arm3", "ln", "-sf", "/var/www/html/airquality/pm4p0-"+today.Format("2006-01-02")+".png", "/var/www/html/airquality/pm4p0-today.png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("ln error=%v\n", error)
-		}
-		os.Remove(f.Name())
-
-		// PM 10.0
-		//
-		graph = chart.Chart{
-			Title:      "PM10.0",
-			Background: chart.Style{Padding: chart.Box{Top: 20, Left: 20, Right: 20, Bottom: 20}},
-			XAxis: chart.XAxis{
-				Style: chart.Style{TextRotationDegrees: 90.0, FontSize: 6},
-				ValueFormatter: func(v interface{}) string {
-					typed := v.(float64)
-					typedDate := chart.TimeFromFloat64(typed)
-					return typedDate.Format("Jan-02-06 15:04")
-				},
-			},
-			YAxis: chart.YAxis{
-				Name:      "µg/m³",
-				NameStyle: chart.Style{FontColor: chart.ColorBlack},
-			},
-			Series: []chart.Series{
-				chart.TimeSeries{
-					YAxis:   chart.YAxisPrimary,
-					XValues: xaxis,
-					YValues: pm10p0,
-					Style: chart.Style{StrokeColor: chart.ColorBlack, DotWidth: 3, DotColorProvider: func(xr, yr chart.Range, index int, x, y float64) drawing.Color {
-						if y < 20 {
-							return drawing.Color{R: 55, G: 172, B: 86, A: 255}
-						}
-						if y < 40 {
-							return drawing.Color{R: 155, G: 212, B: 68, A: 255}
-						}
-						if y < 50 {
-							return drawing.Color{R: 241, G: 210, B: 8, A: 255}
-						}
-						if y < 100 {
-							return drawing.Color{R: 255, G: 187, B: 1, A: 255}
-						}
-						if y < 150 {
-							return drawing.Color{R: 255, G: 140, B: 0, A: 255}
-						}
-						return drawing.Color{R: 237, G: 15, B: 5, A: 255}
-					}},
-				},
-			},
-		}
-		f, _ = os.CreateTemp("", "pm10p0*.png")
-		os.Chmod(f.Name(), 0666)
-		error = graph.Render(chart.PNG, f)
-		if error != nil {
-			fmt.Printf("render error=%v\n", error)
-		}
-		f.Close()
-		cmd = exec.Command("scp", "-p", f.Name(), "arm3:/var/www/html/airquality/pm10p0-"+today.Format("2006-01-02")+".png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("scp error=%v\n", error)
-		}
-		cmd = exec.Command("ssh", "arm3", "ln", "-sf", "/var/www/html/airquality/pm10p0-"+today.Format("2006-01-02")+".png", "/var/www/html/airquality/pm10p0-today.png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("ln error=%v\n", error)
-		}
-		os.Remove(f.Name())
-
-		// Humidity
-		//
-		graph = chart.Chart{
-			Title:      "Humidity",
-			Background: chart.Style{Padding: chart.Box{Top: 20, Left: 20, Right: 20, Bottom: 20}},
-			XAxis: chart.XAxis{
-				Style: chart.Style{TextRotationDegrees: 90.0, FontSize: 6},
-				ValueFormatter: func(v interface{}) string {
-					typed := v.(float64)
-					typedDate := chart.TimeFromFloat64(typed)
-					return typedDate.Format("Jan-02-06 15:04")
-				},
-			},
-			YAxis: chart.YAxis{
-				Name:      "%",
-				NameStyle: chart.Style{FontColor: chart.ColorBlack},
-			},
-			Series: []chart.Series{
-				chart.TimeSeries{
-					YAxis:   chart.YAxisPrimary,
-					XValues: xaxis,
-					YValues: humidity,
-					Style:   chart.Style{StrokeColor: chart.ColorBlack, DotWidth: 3, DotColor: chart.ColorBlue},
-				},
-			},
-		}
-		f, _ = os.CreateTemp("", "humidity*.png")
-		os.Chmod(f.Name(), 0666)
-		error = graph.Render(chart.PNG, f)
-		if error != nil {
-			fmt.Printf("render error=%v\n", error)
-		}
-		f.Close()
-		cmd = exec.Command("scp", "-p", f.Name(), "arm3:/var/www/html/airquality/humidity-"+today.Format("2006-01-02")+".png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("scp error=%v\n", error)
-		}
-		cmd = exec.Command("ssh", "arm3", "ln", "-sf", "/var/www/html/airquality/humidity-"+today.Format("2006-01-02")+".png", "/var/www/html/airquality/humidity-today.png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("ln error=%v\n", error)
-		}
-		os.Remove(f.Name())
-
-		// Temperature
-		//
-		graph = chart.Chart{
-			Title:      "Temperature",
-			Background: chart.Style{Padding: chart.Box{Top: 20, Left: 20, Right: 20, Bottom: 20}},
-			XAxis: chart.XAxis{
-				Style: chart.Style{TextRotationDegrees: 90.0, FontSize: 6},
-				ValueFormatter: func(v interface{}) string {
-					typed := v.(float64)
-					typedDate := chart.TimeFromFloat64(typed)
-					return typedDate.Format("Jan-02-06 15:04")
-				},
-			},
-			YAxis: chart.YAxis{
-				Name:      "°C",
-				NameStyle: chart.Style{FontColor: chart.ColorBlack},
-			},
-			Series: []chart.Series{
-				chart.TimeSeries{
-					YAxis:   chart.YAxisPrimary,
-					XValues: xaxis,
-					YValues: temperature,
-					Style:   chart.Style{StrokeColor: chart.ColorBlack, DotWidth: 3, DotColor: chart.ColorBlue},
-				},
-			},
-		}
-		f, _ = os.CreateTemp("", "temperature*.png")
-		os.Chmod(f.Name(), 0666)
-		error = graph.Render(chart.PNG, f)
-		if error != nil {
-			fmt.Printf("render error=%v\n", error)
-		}
-		f.Close()
-		cmd = exec.Command("scp", "-p", f.Name(), "arm3:/var/www/html/airquality/temperature-"+today.Format("2006-01-02")+".png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("scp error=%v\n", error)
-		}
-		cmd = exec.Command("ssh", "arm3", "ln", "-sf", "/var/www/html/airquality/temperature-"+today.Format("2006-01-02")+".png", "/var/www/html/airquality/temperature-today.png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("ln error=%v\n", error)
-		}
-		os.Remove(f.Name())
-
-		// VOC
-		//
-		graph = chart.Chart{
-			Title:      "VOC",
-			Background: chart.Style{Padding: chart.Box{Top: 20, Left: 20, Right: 20, Bottom: 20}},
-			XAxis: chart.XAxis{
-				Style: chart.Style{TextRotationDegrees: 90.0, FontSize: 6},
-				ValueFormatter: func(v interface{}) string {
-					typed := v.(float64)
-					typedDate := chart.TimeFromFloat64(typed)
-					return typedDate.Format("Jan-02-06 15:04")
-				},
-			},
-			YAxis: chart.YAxis{
-				Name:      "index",
-				NameStyle: chart.Style{FontColor: chart.ColorBlack},
-			},
-			Series: []chart.Series{
-				chart.TimeSeries{
-					YAxis:   chart.YAxisPrimary,
-					XValues: xaxis,
-					YValues: voc,
-					Style: chart.Style{StrokeColor: chart.ColorBlack, DotWidth: 3, DotColorProvider: func(xr, yr chart.Range, index int, x, y float64) drawing.Color {
-						if y < 249 {
-							return chart.ColorBlue
-						}
-						if y < 449 {
-							return chart.ColorGreen
-						}
-						return chart.ColorRed
-					}},
-				},
-			},
-		}
-		f, _ = os.CreateTemp("", "voc*.png")
-		os.Chmod(f.Name(), 0666)
-		error = graph.Render(chart.PNG, f)
-		if error != nil {
-			fmt.Printf("render error=%v\n", error)
-		}
-		f.Close()
-		cmd = exec.Command("scp", "-p", f.Name(), "arm3:/var/www/html/airquality/voc-"+today.Format("2006-01-02")+".png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("scp error=%v\n", error)
-		}
-		cmd = exec.Command("ssh", "arm3", "ln", "-sf", "/var/www/html/airquality/voc-"+today.Format("2006-01-02")+".png", "/var/www/html/airquality/voc-today.png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("ln error=%v\n", error)
-		}
-		os.Remove(f.Name())
-
-		// NOX
-		//
-		graph = chart.Chart{
-			Title:      "NOX",
-			Background: chart.Style{Padding: chart.Box{Top: 20, Left: 20, Right: 20, Bottom: 20}},
-			XAxis: chart.XAxis{
-				Style: chart.Style{TextRotationDegrees: 90.0, FontSize: 6},
-				ValueFormatter: func(v interface{}) string {
-					typed := v.(float64)
-					typedDate := chart.TimeFromFloat64(typed)
-					return typedDate.Format("Jan-02-06 15:04")
-				},
-			},
-			YAxis: chart.YAxis{
-				Name:      "index",
-				NameStyle: chart.Style{FontColor: chart.ColorBlack},
-			},
-			Series: []chart.Series{
-				chart.TimeSeries{
-					YAxis:   chart.YAxisPrimary,
-					XValues: xaxis,
-					YValues: nox,
-					Style: chart.Style{StrokeColor: chart.ColorBlack, DotWidth: 3, DotColorProvider: func(xr, yr chart.Range, index int, x, y float64) drawing.Color {
-						if y < 249 {
-							return chart.ColorBlue
-						}
-						if y < 449 {
-							return chart.ColorGreen
-						}
-						return chart.ColorRed
-					}},
-				},
-			},
-		}
-		f, _ = os.CreateTemp("", "nox*.png")
-		os.Chmod(f.Name(), 0666)
-		error = graph.Render(chart.PNG, f)
-		if error != nil {
-			fmt.Printf("render error=%v\n", error)
-		}
-		f.Close()
-		cmd = exec.Command("scp", "-p", f.Name(), "arm3:/var/www/html/airquality/nox-"+today.Format("2006-01-02")+".png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("scp error=%v\n", error)
-		}
-		cmd = exec.Command("ssh", "arm3", "ln", "-sf", "/var/www/html/airquality/nox-"+today.Format("2006-01-02")+".png", "/var/www/html/airquality/nox-today.png")
-		error = cmd.Run()
-		if error != nil {
-			fmt.Printf("ln error=%v\n", error)
+	// serve the live dashboard
+	//
+	if options.HTTP.Listen != "" {
+		server := NewDashboardServer(options.HTTP.Listen, d.history)
+		err = server.Start()
+		if err != nil {
+			fmt.Printf("http error=%v\n", err)
+		} else {
+			d.server = server
+			fmt.Printf("Serving the dashboard on %s\n", options.HTTP.Listen)
 		}
-		os.Remove(f.Name())
+	}
+
+	var scheduler Scheduler
+	d.scheduler = &scheduler
+	scheduler.Every("sample", scaleInterval(options.Interval.Sample), d.sample)
+	scheduler.Every("publish", scaleInterval(options.Interval.Publish), d.publish)
+	scheduler.Every("render", scaleInterval(options.Interval.Render), d.render)
+	scheduler.Every("upload", scaleInterval(options.Interval.Upload), d.upload)
+	go scheduler.Run(ctx)
 
-		// FIX THIS ... need to run this periodically, not sleep
-		//
-		time.Sleep(1 * time.Minute)
+	for ctx.Err() == nil {
+		select {
+		case <-hup:
+			d.reload(&scheduler)
+		case <-ctx.Done():
+		}
 	}
+	signal.Stop(hup)
 
+	// the sensor is stopped even if shutting down takes too long
+	//
+	time.AfterFunc(options.ShutdownTimeout, func() {
+		fmt.Printf("Shutdown timed out after %v\n", options.ShutdownTimeout)
+		d.stopSensor()
+		os.Exit(1)
+	})
+
+	// wait for the jobs using the sensor, store and broker, but not for an
+	// upload, which can take longer than the shutdown timeout
+	//
+	scheduler.Pause("sample", "publish")
+	d.shutdown()
+}
+
+// scaleInterval scales a schedule interval by the speed recorded data is
+// replayed at
+func scaleInterval(interval time.Duration) time.Duration {
+	if options.Sensor == "replay" {
+		return time.Duration(float64(interval) / options.ReplaySpeed)
+	}
+	return interval
 }
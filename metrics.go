@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/wcharczuk/go-chart/v2"
+	"github.com/wcharczuk/go-chart/v2/drawing"
+)
+
+// Metric describes one of the sensor's readings
+//
+// Everything that handles readings - home assistant discovery and state,
+// recording, the history store and the charts - works from the metrics
+// table, so adding a reading is one entry here plus a Measurement field.
+type Metric struct {
+	Key         string // used in topics, file names and stored records
+	Name        string // display name
+	Unit        string // unit of measurement, empty for an index
+	DeviceClass string // home assistant device_class, empty for none
+	Icon        string // home assistant icon, for metrics without a device_class
+	StateClass  string // home assistant state_class
+	Precision   int    // decimal places shown and published
+	ChartTitle  string
+
+	// dots are Colors[i] below Thresholds[i], and the last colour above them all
+	Thresholds []float64
+	Colors     []drawing.Color
+
+	// Field returns the metric's field in a measurement
+	Field func(m *Measurement) *float64
+}
+
+var (
+	colorGood     = drawing.Color{R: 55, G: 172, B: 86, A: 255}
+	colorFair     = drawing.Color{R: 155, G: 212, B: 68, A: 255}
+	colorModerate = drawing.Color{R: 241, G: 210, B: 8, A: 255}
+	colorPoor     = drawing.Color{R: 255, G: 187, B: 1, A: 255}
+	colorVeryPoor = drawing.Color{R: 255, G: 140, B: 0, A: 255}
+	colorSevere   = drawing.Color{R: 237, G: 15, B: 5, A: 255}
+
+	particulateColors = []drawing.Color{colorGood, colorFair, colorModerate, colorPoor, colorVeryPoor, colorSevere}
+	indexColors       = []drawing.Color{chart.ColorBlue, chart.ColorGreen, chart.ColorRed}
+)
+
+var metrics = []Metric{
+	{
+		Key: "pm1p0", Name: "PM1.0", Unit: "µg/m³", DeviceClass: "pm1", StateClass: "measurement", Precision: 1, ChartTitle: "PM1.0",
+		Thresholds: []float64{10, 20, 25, 50, 75}, Colors: particulateColors,
+		Field: func(m *Measurement) *float64 { return &m.PM1p0 },
+	},
+	{
+		Key: "pm2p5", Name: "PM2.5", Unit: "µg/m³", DeviceClass: "pm25", StateClass: "measurement", Precision: 1, ChartTitle: "PM2.5",
+		Thresholds: []float64{10, 20, 25, 50, 75}, Colors: particulateColors,
+		Field: func(m *Measurement) *float64 { return &m.PM2p5 },
+	},
+	{
+		Key: "pm4p0", Name: "PM4.0", Unit: "µg/m³", Icon: "mdi:blur", StateClass: "measurement", Precision: 1, ChartTitle: "PM4.0",
+		Thresholds: []float64{20, 40, 50, 100, 150}, Colors: particulateColors,
+		Field: func(m *Measurement) *float64 { return &m.PM4p0 },
+	},
+	{
+		Key: "pm10p0", Name: "PM10", Unit: "µg/m³", DeviceClass: "pm10", StateClass: "measurement", Precision: 1, ChartTitle: "PM10.0",
+		Thresholds: []float64{20, 40, 50, 100, 150}, Colors: particulateColors,
+		Field: func(m *Measurement) *float64 { return &m.PM10p0 },
+	},
+	{
+		Key: "humidity", Name: "Humidity", Unit: "%", DeviceClass: "humidity", StateClass: "measurement", Precision: 1, ChartTitle: "Humidity",
+		Colors: []drawing.Color{chart.ColorBlue},
+		Field:  func(m *Measurement) *float64 { return &m.Humidity },
+	},
+	{
+		Key: "temperature", Name: "Temperature", Unit: "°C", DeviceClass: "temperature", StateClass: "measurement", Precision: 1, ChartTitle: "Temperature",
+		Colors: []drawing.Color{chart.ColorBlue},
+		Field:  func(m *Measurement) *float64 { return &m.Temperature },
+	},
+	{
+		Key: "voc", Name: "VOC", Icon: "mdi:air-filter", StateClass: "measurement", Precision: 1, ChartTitle: "VOC",
+		Thresholds: []float64{249, 449}, Colors: indexColors,
+		Field: func(m *Measurement) *float64 { return &m.VOC },
+	},
+	{
+		Key: "nox", Name: "NOX", Icon: "mdi:molecule", StateClass: "measurement", Precision: 1, ChartTitle: "NOX",
+		Thresholds: []float64{249, 449}, Colors: indexColors,
+		Field: func(m *Measurement) *float64 { return &m.NOx },
+	},
+}
+
+// findMetric returns the metric with the given key
+func findMetric(key string) (*Metric, error) {
+	for i := range metrics {
+		if metrics[i].Key == key {
+			return &metrics[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown metric %q", key)
+}
+
+// AxisName is the chart y axis label
+func (metric *Metric) AxisName() string {
+	if metric.Unit == "" {
+		return "index"
+	}
+	return metric.Unit
+}
+
+// Format formats a value to the metric's precision
+func (metric *Metric) Format(v float64) string {
+	return fmt.Sprintf("%.*f", metric.Precision, v)
+}
+
+// Color returns the colour of a value on the chart
+func (metric *Metric) Color(v float64) drawing.Color {
+	for i, threshold := range metric.Thresholds {
+		if v < threshold && i < len(metric.Colors) {
+			return metric.Colors[i]
+		}
+	}
+	return metric.Colors[len(metric.Colors)-1]
+}
+
+// ChartStyle is the style of the metric's chart series
+func (metric *Metric) ChartStyle() chart.Style {
+	return chart.Style{StrokeColor: chart.ColorBlack, DotWidth: 3, DotColorProvider: func(xr, yr chart.Range, index int, x, y float64) drawing.Color {
+		return metric.Color(y)
+	}}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// availabilityTopic is where home assistant is told if we are online
+func availabilityTopic() string {
+	return options.BaseTopic + "/availability"
+}
+
+// stateTopic is where a metric's readings are published
+func stateTopic(metric *Metric) string {
+	if options.HomeAssistant.State == "json" {
+		return jsonStateTopic()
+	}
+	return options.BaseTopic + "/air_quality_" + metric.Key + "/state"
+}
+
+// jsonStateTopic is where all the readings are published together as JSON
+func jsonStateTopic() string {
+	return options.BaseTopic + "/state"
+}
+
+// valueTemplate extracts a metric's reading from its state topic
+func valueTemplate(metric *Metric) string {
+	if options.HomeAssistant.State == "json" {
+		return "{{ value_json." + metric.Key + " }}"
+	}
+	return "{{ value | float(0) }}"
+}
+
+// expireAfter is how long home assistant keeps a reading before marking it
+// unavailable
+func expireAfter() time.Duration {
+	if options.HomeAssistant.ExpireAfter > 0 {
+		return options.HomeAssistant.ExpireAfter
+	}
+	return 3 * options.Interval.Publish
+}
+
+// mqttTimeout is the longest to wait for the broker to accept a publish
+const mqttTimeout = 5 * time.Second
+
+// maxBackoff is the longest to wait between connection attempts
+const maxBackoff = time.Minute
+
+// newMQTTClient returns a client for the broker
+//
+// The broker publishes "offline" to the availability topic for us if we
+// go away without disconnecting, and we publish "online" and the discovery
+// configs every time we connect, so home assistant picks us up again after
+// either end restarts.
+func newMQTTClient(info SensorInfo) (mqtt.Client, error) {
+	//mqtt.DEBUG = log.New(os.Stdout, "", 0)
+	mqtt.ERROR = log.New(os.Stdout, "", 0)
+	opts := mqtt.NewClientOptions().AddBroker(options.Broker).SetClientID(options.ClientID)
+	opts.SetKeepAlive(2 * time.Second)
+	opts.SetPingTimeout(1 * time.Second)
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(maxBackoff)
+	opts = opts.SetUsername(options.Username)
+	opts = opts.SetPassword(options.Password)
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	opts.SetWill(availabilityTopic(), "offline", 0, true)
+	opts.SetOnConnectHandler(func(c mqtt.Client) {
+		optionsMu.RLock()
+		defer optionsMu.RUnlock()
+
+		fmt.Printf("Connected to %s\n", options.Broker)
+		token := c.Publish(availabilityTopic(), 0, true, "online")
+		if token.Wait() && token.Error() != nil {
+			fmt.Printf("mqtt error=%v\n", token.Error())
+		}
+		publishDiscovery(c, info)
+	})
+	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+		fmt.Printf("mqtt connection lost error=%v\n", err)
+	})
+	return mqtt.NewClient(opts), nil
+}
+
+// connectMQTT connects to the broker, retrying with backoff until it
+// succeeds or ctx is done
+//
+// Once connected the client reconnects by itself.
+func connectMQTT(ctx context.Context, c mqtt.Client) {
+	backoff := time.Second
+	for {
+		token := c.Connect()
+		token.Wait()
+		if token.Error() == nil {
+			return
+		}
+		fmt.Printf("mqtt error=%v, retrying in %v\n", token.Error(), backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// publishToken waits for a publish to be accepted by the broker
+func publishToken(token mqtt.Token) error {
+	if !token.WaitTimeout(mqttTimeout) {
+		return fmt.Errorf("timed out publishing")
+	}
+	return token.Error()
+}
+
+// newTLSConfig returns the TLS settings for ssl:// and wss:// brokers, or
+// nil to use the defaults
+func newTLSConfig() (*tls.Config, error) {
+	o := options.TLS
+	if o.CA == "" && o.Cert == "" && o.ServerName == "" && !o.InsecureSkipVerify {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         o.ServerName,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+	if o.CA != "" {
+		pem, err := os.ReadFile(o.CA)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CA)
+		}
+	}
+	if o.Cert != "" {
+		cert, err := tls.LoadX509KeyPair(o.Cert, o.Key)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// discoveryDevice is the home assistant device that every sensor belongs to
+func discoveryDevice(info SensorInfo) map[string]interface{} {
+	id := "airquality"
+	if info.SerialNumber != "" {
+		id = "airquality_" + info.SerialNumber
+	}
+	device := map[string]interface{}{
+		"identifiers":  []string{id},
+		"name":         options.HomeAssistant.DeviceName,
+		"manufacturer": "Sensirion",
+	}
+	if info.ProductName != "" {
+		device["model"] = info.ProductName
+	}
+	if info.SerialNumber != "" {
+		device["serial_number"] = info.SerialNumber
+	}
+	if info.FirmwareVersion != "" {
+		device["sw_version"] = info.FirmwareVersion
+	}
+	if options.HomeAssistant.Area != "" {
+		device["suggested_area"] = options.HomeAssistant.Area
+	}
+	return device
+}
+
+// publishDiscovery publishes the home assistant discovery configs
+func publishDiscovery(c mqtt.Client, info SensorInfo) {
+	device := discoveryDevice(info)
+
+	for i := range metrics {
+		metric := &metrics[i]
+		config := map[string]interface{}{
+			"name":               metric.Name,
+			"unique_id":          "air_quality_" + metric.Key,
+			"object_id":          "air_quality_" + metric.Key,
+			"state_topic":        stateTopic(metric),
+			"value_template":     valueTemplate(metric),
+			"availability_topic": availabilityTopic(),
+			"device":             device,
+			"state_class":        metric.StateClass,
+			"expire_after":       int(expireAfter().Seconds()),
+
+			"suggested_display_precision": metric.Precision,
+		}
+		if metric.Unit != "" {
+			config["unit_of_measurement"] = metric.Unit
+		}
+		if metric.DeviceClass != "" {
+			config["device_class"] = metric.DeviceClass
+		}
+		if metric.Icon != "" {
+			config["icon"] = metric.Icon
+		}
+		payload, _ := json.Marshal(config)
+		err := publishToken(c.Publish(options.BaseTopic+"/air_quality_"+metric.Key+"/config", 0, true, payload))
+		if err != nil {
+			fmt.Printf("mqtt error=%v\n", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+)
+
+// Publisher copies rendered charts to wherever they are served from
+//
+// Names are relative to the publisher's root and use / as the separator.
+type Publisher interface {
+	// Publish copies the local file at path to name
+	Publish(path string, name string) error
+
+	// Alias makes alias refer to the already published name, eg
+	// pm2p5-today.png to today's dated chart
+	Alias(alias string, name string) error
+
+	Close() error
+}
+
+// newPublisher returns the publisher selected by the options, nil if
+// nothing is published
+func newPublisher() (Publisher, error) {
+	switch options.Upload.Publisher {
+	case "none":
+		return nil, nil
+	case "local":
+		return NewLocalPublisher(options.Upload.Dir), nil
+	case "s3":
+		u := options.Upload
+		publisher, err := NewS3Publisher(u.Endpoint, u.Bucket, u.Region, u.Prefix, u.AccessKey, u.SecretKey, u.CacheControl)
+		if err != nil {
+			return nil, err
+		}
+		return publisher, nil
+	case "webdav":
+		publisher, err := NewWebDAVPublisher(options.Upload.URL, options.Upload.User, options.Upload.Password)
+		if err != nil {
+			return nil, err
+		}
+		return publisher, nil
+	case "command":
+		return NewHookPublisher(options.Upload.Command), nil
+	case "sftp":
+		u := options.Upload
+		if u.User == "" {
+			current, err := user.Current()
+			if err != nil {
+				return nil, err
+			}
+			u.User = current.Username
+		}
+		if len(u.Key) == 0 {
+			u.Key = defaultSSHKeys()
+		}
+		if u.KnownHosts == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, err
+			}
+			u.KnownHosts = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		publisher, err := NewSFTPPublisher(u.Host, u.User, u.Key, u.KnownHosts, u.Dir)
+		if err != nil {
+			return nil, err
+		}
+		return publisher, nil
+	default:
+		return nil, fmt.Errorf("unknown publisher %q", options.Upload.Publisher)
+	}
+}
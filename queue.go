@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Queue holds readings that could not be published while the broker was
+// away
+//
+// Readings are kept in memory and, if a path is given, in a file so they
+// survive a restart.  Only the newest size readings are kept, older ones
+// are dropped as new ones arrive.
+type Queue struct {
+	path     string
+	size     int
+	mu       sync.Mutex
+	readings []Measurement
+}
+
+// OpenQueue returns a queue of at most size readings, loading any left in
+// the file at path
+func OpenQueue(path string, size int) (*Queue, error) {
+	q := &Queue{path: path, size: size}
+	if path == "" {
+		return q, nil
+	}
+
+	readings, err := readMeasurements(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	q.readings = readings
+	if len(q.readings) > size {
+		q.readings = q.readings[len(q.readings)-size:]
+		err = q.save()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// Len returns the number of queued readings
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.readings)
+}
+
+// Push adds a reading to the end of the queue
+func (q *Queue) Push(m Measurement) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.readings = append(q.readings, m)
+	if len(q.readings) > q.size {
+		q.readings = q.readings[len(q.readings)-q.size:]
+		return q.save()
+	}
+	if q.path == "" {
+		return nil
+	}
+	r, err := NewRecorder(q.path)
+	if err != nil {
+		return err
+	}
+	err = r.Write(m)
+	if err != nil {
+		r.Close()
+		return err
+	}
+	return r.Close()
+}
+
+// Drain passes the queued readings to publish, oldest first, removing each
+// one that is published.  It stops at the first error and returns it.
+func (q *Queue) Drain(publish func(m Measurement) error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.readings) == 0 {
+		return nil
+	}
+
+	var err error
+	n := 0
+	for _, m := range q.readings {
+		err = publish(m)
+		if err != nil {
+			break
+		}
+		n++
+	}
+	q.readings = q.readings[n:]
+
+	if n > 0 {
+		saveErr := q.save()
+		if err == nil {
+			err = saveErr
+		}
+	}
+	return err
+}
+
+// save replaces the file with the queued readings
+func (q *Queue) save() error {
+	if q.path == "" {
+		return nil
+	}
+	if len(q.readings) == 0 {
+		err := os.Remove(q.path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	// keep the extension so the temporary file is written in the same format
+	//
+	f, err := os.CreateTemp(filepath.Dir(q.path), ".queue*"+filepath.Ext(q.path))
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	r, err := NewRecorder(f.Name())
+	if err != nil {
+		return err
+	}
+	for _, m := range q.readings {
+		err = r.Write(m)
+		if err != nil {
+			r.Close()
+			return err
+		}
+	}
+	err = r.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), q.path)
+}
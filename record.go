@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvHeader returns the CSV column names
+func csvHeader() []string {
+	header := []string{"time"}
+	for _, metric := range metrics {
+		header = append(header, metric.Key)
+	}
+	return header
+}
+
+// JSON has no NaN so missing values (eg NOx during warm-up) are null
+
+// jsonFields returns the measurement as a map ready to marshal to JSON
+func (m Measurement) jsonFields() map[string]interface{} {
+	j := map[string]interface{}{"time": m.Time}
+	for i, v := range m.values() {
+		if math.IsNaN(*v) || math.IsInf(*v, 0) {
+			j[metrics[i].Key] = nil
+		} else {
+			j[metrics[i].Key] = *v
+		}
+	}
+	return j
+}
+
+func (m Measurement) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.jsonFields())
+}
+
+func (m *Measurement) UnmarshalJSON(data []byte) error {
+	var j map[string]json.RawMessage
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return err
+	}
+	*m = Measurement{}
+	err = json.Unmarshal(j["time"], &m.Time)
+	if err != nil {
+		return err
+	}
+	for i, v := range m.values() {
+		var value *float64
+		raw, ok := j[metrics[i].Key]
+		if ok {
+			err = json.Unmarshal(raw, &value)
+			if err != nil {
+				return fmt.Errorf("%s: %v", metrics[i].Key, err)
+			}
+		}
+		if value == nil {
+			*v = math.NaN()
+		} else {
+			*v = *value
+		}
+	}
+	return nil
+}
+
+// isCSV returns true if the file should be written as CSV rather than JSONL
+func isCSV(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".csv")
+}
+
+// Recorder appends measurements to a file, as CSV if the file name ends
+// in .csv and as JSON lines otherwise
+type Recorder struct {
+	file *os.File
+	csv  *csv.Writer
+}
+
+// NewRecorder opens path for appending measurements
+func NewRecorder(path string) (*Recorder, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	r := &Recorder{file: f}
+	if isCSV(path) {
+		r.csv = csv.NewWriter(f)
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if stat.Size() == 0 {
+			r.csv.Write(csvHeader())
+			r.csv.Flush()
+		}
+	}
+	return r, nil
+}
+
+// Write appends one measurement
+func (r *Recorder) Write(m Measurement) error {
+	if r.csv != nil {
+		record := []string{m.Time.Format(time.RFC3339Nano)}
+		for _, v := range m.values() {
+			record = append(record, strconv.FormatFloat(*v, 'f', -1, 64))
+		}
+		r.csv.Write(record)
+		r.csv.Flush()
+		return r.csv.Error()
+	}
+
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	_, err = r.file.Write(append(data, '\n'))
+	return err
+}
+
+// Close flushes the recording to disk and closes it
+func (r *Recorder) Close() error {
+	err := r.file.Sync()
+	if err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
+
+// readMeasurements reads a file written by Recorder
+func readMeasurements(path string) ([]Measurement, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var measurements []Measurement
+	if isCSV(path) {
+		header := csvHeader()
+		reader := csv.NewReader(f)
+		line := 0
+		for {
+			record, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			line++
+			if err != nil {
+				return nil, err
+			}
+			if line == 1 && record[0] == header[0] {
+				continue
+			}
+			if len(record) != len(header) {
+				return nil, fmt.Errorf("%s:%d: expected %d fields, got %d", path, line, len(header), len(record))
+			}
+			var m Measurement
+			m.Time, err = time.Parse(time.RFC3339Nano, record[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+			}
+			for i, v := range m.values() {
+				*v, err = strconv.ParseFloat(record[i+1], 64)
+				if err != nil {
+					return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+				}
+			}
+			measurements = append(measurements, m)
+		}
+		return measurements, nil
+	}
+
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var m Measurement
+		err = json.Unmarshal(scanner.Bytes(), &m)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		measurements = append(measurements, m)
+	}
+	return measurements, scanner.Err()
+}
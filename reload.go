@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// optionsMu guards options and the metric thresholds against a reload for
+// code that runs outside the scheduled jobs that reload pauses
+var optionsMu sync.RWMutex
+
+// reloadOptions reads the config file, credentials, environment and
+// command line again and checks the result, without changing the running
+// options
+func reloadOptions(args []string) (*Options, error) {
+	next := &Options{}
+	p := newParser(next, flags.None)
+	err := loadConfig(p, args)
+	if err != nil {
+		return nil, err
+	}
+	err = loadCredentials(p, args)
+	if err != nil {
+		return nil, err
+	}
+	_, err = p.ParseArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	err = checkOptions(next)
+	if err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// keepFixedOptions copies the options that only take effect at startup -
+// the broker connection, sensor, store, queue and dashboard address - from
+// the running options to next, and logs any that were changed
+func keepFixedOptions(next *Options) {
+	fixed := []struct {
+		name      string
+		old, next interface{}
+	}{
+		{"broker", &options.Broker, &next.Broker},
+		{"username", &options.Username, &next.Username},
+		{"password", &options.Password, &next.Password},
+		{"credentials", &options.Credentials, &next.Credentials},
+		{"client-id", &options.ClientID, &next.ClientID},
+		{"basetopic", &options.BaseTopic, &next.BaseTopic},
+		{"tls", &options.TLS, &next.TLS},
+		{"sensor", &options.Sensor, &next.Sensor},
+		{"seed", &options.Seed, &next.Seed},
+		{"record", &options.Record, &next.Record},
+		{"replay-file", &options.ReplayFile, &next.ReplayFile},
+		{"replay-speed", &options.ReplaySpeed, &next.ReplaySpeed},
+		{"i2c", &options.I2C, &next.I2C},
+		{"store", &options.Store, &next.Store},
+		{"queue", &options.Queue, &next.Queue},
+		{"http.listen", &options.HTTP.Listen, &next.HTTP.Listen},
+	}
+	for _, f := range fixed {
+		old := reflect.ValueOf(f.old).Elem()
+		changed := reflect.ValueOf(f.next).Elem()
+		if !reflect.DeepEqual(old.Interface(), changed.Interface()) {
+			fmt.Printf("%s changed, restart to apply it\n", f.name)
+			changed.Set(old)
+		}
+	}
+}
+
+// reload applies a changed configuration to the scheduler, publishers and
+// charts on SIGHUP
+//
+// The sensor, broker connection and history are left running, so nothing
+// collected so far is lost.  The jobs that read the options are held back
+// while they change, but sampling reads none so never stops.  If the new
+// configuration is invalid the running one is kept.
+func (d *Daemon) reload(scheduler *Scheduler) {
+	next, err := reloadOptions(os.Args[1:])
+	if err != nil {
+		fmt.Printf("reload error=%v, keeping the running configuration\n", err)
+		return
+	}
+	keepFixedOptions(next)
+
+	// wait for a slow upload before holding back the others
+	//
+	resume := scheduler.Pause("upload", "render", "publish")
+	uploadChanged := !reflect.DeepEqual(options.Upload, next.Upload)
+	optionsMu.Lock()
+	options = *next
+	applyThresholds(&options)
+	optionsMu.Unlock()
+	if uploadChanged {
+		d.setPublisher()
+	}
+	if options.Sensor != "replay" {
+		scheduler.SetInterval("sample", scaleInterval(options.Interval.Sample))
+	}
+	scheduler.SetInterval("publish", scaleInterval(options.Interval.Publish))
+	scheduler.SetInterval("render", scaleInterval(options.Interval.Render))
+	scheduler.SetInterval("upload", scaleInterval(options.Interval.Upload))
+	resume()
+
+	// home assistant settings may have changed
+	//
+	if d.client.IsConnectionOpen() {
+		publishDiscovery(d.client, d.info)
+	}
+	fmt.Printf("Configuration reloaded\n")
+}
+
+// setPublisher replaces the publisher with one for the current options,
+// keeping the old one if the new one can't be made
+func (d *Daemon) setPublisher() {
+	publisher, err := newPublisher()
+	if err != nil {
+		fmt.Printf("upload error=%v, keeping the running publisher\n", err)
+		return
+	}
+	if d.publisher != nil {
+		err = d.publisher.Close()
+		if err != nil {
+			fmt.Printf("upload error=%v\n", err)
+		}
+	}
+	d.publisher = publisher
+}
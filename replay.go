@@ -0,0 +1,67 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// ReplaySensor plays back measurements saved by a Recorder
+//
+// Each Read returns the next recorded measurement, with its original
+// timestamp, until the recording runs out and io.EOF is returned.  Next
+// says when the following measurement is due, so the reads can be spaced
+// as they were recorded.
+type ReplaySensor struct {
+	path         string
+	speed        float64
+	measurements []Measurement
+	next         int
+}
+
+// NewReplaySensor returns a sensor replaying the recording at path, speed
+// times faster than it was recorded
+func NewReplaySensor(path string, speed float64) *ReplaySensor {
+	return &ReplaySensor{path: path, speed: speed}
+}
+
+func (s *ReplaySensor) Init() error {
+	measurements, err := readMeasurements(s.path)
+	if err != nil {
+		return err
+	}
+	s.measurements = measurements
+	s.next = 0
+	return nil
+}
+
+func (s *ReplaySensor) Info() (SensorInfo, error) {
+	return SensorInfo{SerialNumber: "REPLAY", ProductName: s.path}, nil
+}
+
+func (s *ReplaySensor) Start() error {
+	return nil
+}
+
+func (s *ReplaySensor) Read() (Measurement, error) {
+	if s.next >= len(s.measurements) {
+		return Measurement{}, io.EOF
+	}
+	m := s.measurements[s.next]
+	s.next++
+	return m, nil
+}
+
+// Next returns how long after the last measurement read the next one is
+// due, the recorded time between them divided by the speed, false if there
+// is no next one
+func (s *ReplaySensor) Next() (time.Duration, bool) {
+	if s.next == 0 || s.next >= len(s.measurements) {
+		return 0, false
+	}
+	gap := s.measurements[s.next].Time.Sub(s.measurements[s.next-1].Time)
+	return time.Duration(float64(gap) / s.speed), true
+}
+
+func (s *ReplaySensor) Stop() error {
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReplayNext(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.jsonl")
+	r, err := NewRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	for _, offset := range []time.Duration{0, 10 * time.Second, 70 * time.Second} {
+		err = r.Write(sample(start.Add(offset), 1, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = r.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewReplaySensor(path, 2)
+	err = s.Init()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Next(); ok {
+		t.Errorf("next is known before the first read")
+	}
+	for _, want := range []time.Duration{5 * time.Second, 30 * time.Second} {
+		_, err = s.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if gap, ok := s.Next(); !ok || gap != want {
+			t.Errorf("next is %v, %v, want %v", gap, ok, want)
+		}
+	}
+	_, err = s.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Next(); ok {
+		t.Errorf("next is known after the last read")
+	}
+	if _, err = s.Read(); err != io.EOF {
+		t.Errorf("got %v after the last read, want io.EOF", err)
+	}
+}
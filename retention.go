@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// history tiers, each a directory of daily segments under the store
+const (
+	tierRaw       = ""
+	tierAggregate = "aggregate"
+	tierHourly    = "hourly"
+)
+
+// Retention says how long each tier of history is kept
+//
+// Raw samples are kept for RawDays, then downsampled into Aggregate sized
+// buckets which are kept for AggregateDays, then downsampled again into
+// hourly buckets which are kept for HourlyDays.  Zero days keeps a tier
+// forever.
+type Retention struct {
+	RawDays       int
+	Aggregate     time.Duration
+	AggregateDays int
+	HourlyDays    int
+}
+
+// Aggregate summarises the measurements in one time bucket
+type Aggregate struct {
+	Time  time.Time   `json:"time"`
+	Count int         `json:"count"`
+	Min   Measurement `json:"min"`
+	Mean  Measurement `json:"mean"`
+	Max   Measurement `json:"max"`
+}
+
+// newAggregate returns a single measurement as an Aggregate
+func newAggregate(m Measurement) Aggregate {
+	return Aggregate{Time: m.Time, Count: 1, Min: m, Mean: m, Max: m}
+}
+
+// downsample merges aggregates into buckets of the given size
+//
+// Means are weighted by count and missing (NaN) values are ignored.
+func downsample(in []Aggregate, bucket time.Duration) []Aggregate {
+	var out []Aggregate
+	var weights []float64
+	var current *Aggregate
+	for _, a := range in {
+		start := a.Time.Truncate(bucket)
+		if current == nil || !current.Time.Equal(start) {
+			if current != nil {
+				out = append(out, finishAggregate(*current, weights))
+			}
+			current = &Aggregate{Time: start}
+			for _, m := range []*Measurement{&current.Min, &current.Mean, &current.Max} {
+				m.Time = start
+				for _, v := range m.values() {
+					*v = math.NaN()
+				}
+			}
+			weights = make([]float64, len(current.Mean.values()))
+		}
+
+		current.Count += a.Count
+		mins, means, maxs := current.Min.values(), current.Mean.values(), current.Max.values()
+		aMins, aMeans, aMaxs := a.Min.values(), a.Mean.values(), a.Max.values()
+		for i := range means {
+			if math.IsNaN(*aMeans[i]) {
+				continue
+			}
+			if math.IsNaN(*mins[i]) || *aMins[i] < *mins[i] {
+				*mins[i] = *aMins[i]
+			}
+			if math.IsNaN(*maxs[i]) || *aMaxs[i] > *maxs[i] {
+				*maxs[i] = *aMaxs[i]
+			}
+			if math.IsNaN(*means[i]) {
+				*means[i] = 0
+			}
+			*means[i] += *aMeans[i] * float64(a.Count)
+			weights[i] += float64(a.Count)
+		}
+	}
+	if current != nil {
+		out = append(out, finishAggregate(*current, weights))
+	}
+	return out
+}
+
+// finishAggregate turns the weighted sums in Mean into means
+func finishAggregate(a Aggregate, weights []float64) Aggregate {
+	for i, v := range a.Mean.values() {
+		if weights[i] > 0 {
+			*v /= weights[i]
+		}
+	}
+	return a
+}
+
+// readAggregates reads a segment of any tier as aggregates
+func readAggregates(path string, tier string) ([]Aggregate, error) {
+	if tier == tierRaw {
+		measurements, err := readMeasurements(path)
+		if err != nil {
+			return nil, err
+		}
+		aggregates := make([]Aggregate, len(measurements))
+		for i, m := range measurements {
+			aggregates[i] = newAggregate(m)
+		}
+		return aggregates, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var aggregates []Aggregate
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		var a Aggregate
+		err = json.Unmarshal(scanner.Bytes(), &a)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		aggregates = append(aggregates, a)
+	}
+	return aggregates, scanner.Err()
+}
+
+// writeAggregates atomically replaces path with the aggregates
+func writeAggregates(path string, aggregates []Aggregate) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".compact*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	for _, a := range aggregates {
+		data, err := json.Marshal(a)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// segmentDays returns the days that have a segment in the tier, oldest first
+func (s *Store) segmentDays(tier string) ([]time.Time, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, tier, "*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	var days []time.Time
+	for _, path := range paths {
+		day, err := time.ParseInLocation(segmentDateFormat, strings.TrimSuffix(filepath.Base(path), ".jsonl"), time.Local)
+		if err == nil {
+			days = append(days, day)
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days, nil
+}
+
+// compactTier downsamples segments of one tier older than days into the next
+// tier, or deletes them if there is no next tier
+func (s *Store) compactTier(now time.Time, from string, days int, to string, bucket time.Duration) error {
+	if days <= 0 {
+		return nil
+	}
+	cutoff := startOfDay(now).AddDate(0, 0, -days)
+	segments, err := s.segmentDays(from)
+	if err != nil {
+		return err
+	}
+	for _, day := range segments {
+		if !day.Before(cutoff) {
+			break
+		}
+		if from == tierRaw && day.Format(segmentDateFormat) == s.day {
+			// still being appended to
+			continue
+		}
+		path := s.segmentPath(from, day.Format(segmentDateFormat))
+		if to != "" {
+			aggregates, err := readAggregates(path, from)
+			if err != nil {
+				return err
+			}
+
+			// merge with anything already downsampled for the day
+			//
+			target := s.segmentPath(to, day.Format(segmentDateFormat))
+			existing, err := readAggregates(target, to)
+			if err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			aggregates = append(existing, aggregates...)
+			sort.SliceStable(aggregates, func(i, j int) bool { return aggregates[i].Time.Before(aggregates[j].Time) })
+
+			err = writeAggregates(target, downsample(aggregates, bucket))
+			if err != nil {
+				return err
+			}
+		}
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compact enforces the retention tiers
+func (s *Store) Compact(now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.compactTier(now, tierRaw, s.retention.RawDays, tierAggregate, s.retention.Aggregate)
+	if err != nil {
+		return err
+	}
+	err = s.compactTier(now, tierAggregate, s.retention.AggregateDays, tierHourly, time.Hour)
+	if err != nil {
+		return err
+	}
+	return s.compactTier(now, tierHourly, s.retention.HourlyDays, "", 0)
+}
+
+// RunCompactor compacts the store every interval until ctx is done
+func (s *Store) RunCompactor(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		err := s.Compact(time.Now())
+		if err != nil {
+			fmt.Printf("compact error=%v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"math"
+	"os"
+	"testing"
+	"time"
+)
+
+// sample returns a measurement with every particulate metric set to pm
+func sample(t time.Time, pm, nox float64) Measurement {
+	return Measurement{Time: t, PM1p0: pm, PM2p5: pm, PM4p0: pm, PM10p0: pm, Humidity: 50, Temperature: 20, VOC: 100, NOx: nox}
+}
+
+func readTier(t *testing.T, s *Store, tier string, day time.Time) []Aggregate {
+	t.Helper()
+	aggregates, err := readAggregates(s.segmentPath(tier, day.Format(segmentDateFormat)), tier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aggregates
+}
+
+func checkAggregate(t *testing.T, a Aggregate, at time.Time, count int, min, mean, max, nox float64) {
+	t.Helper()
+	if !a.Time.Equal(at) || a.Count != count {
+		t.Errorf("got %d at %s, want %d at %s", a.Count, a.Time.Format(time.Kitchen), count, at.Format(time.Kitchen))
+	}
+	if a.Min.PM2p5 != min || a.Mean.PM2p5 != mean || a.Max.PM2p5 != max {
+		t.Errorf("%s pm2p5 is %v/%v/%v, want %v/%v/%v", at.Format(time.Kitchen), a.Min.PM2p5, a.Mean.PM2p5, a.Max.PM2p5, min, mean, max)
+	}
+	if a.Mean.NOx != nox && !(math.IsNaN(a.Mean.NOx) && math.IsNaN(nox)) {
+		t.Errorf("%s nox is %v, want %v", at.Format(time.Kitchen), a.Mean.NOx, nox)
+	}
+}
+
+func exists(s *Store, tier string, day time.Time) bool {
+	_, err := os.Stat(s.segmentPath(tier, day.Format(segmentDateFormat)))
+	return err == nil
+}
+
+func TestCompact(t *testing.T) {
+	s, err := OpenStore(t.TempDir(), Retention{RawDays: 2, Aggregate: 5 * time.Minute, AggregateDays: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	old := time.Date(2026, 10, 10, 0, 0, 0, 0, time.Local)
+	yesterday := time.Date(2026, 10, 16, 0, 0, 0, 0, time.Local)
+	today := time.Date(2026, 10, 17, 0, 0, 0, 0, time.Local)
+	at := func(day time.Time, hour, min int) time.Time {
+		return day.Add(time.Duration(hour)*time.Hour + time.Duration(min)*time.Minute)
+	}
+	nan := math.NaN()
+	for _, m := range []Measurement{
+		sample(at(old, 10, 0), 10, nan),
+		sample(at(old, 10, 1), 20, 100),
+		sample(at(old, 10, 4), 30, 200),
+		sample(at(old, 10, 5), 40, nan),
+		sample(at(old, 11, 0), 50, 300),
+		sample(at(yesterday, 9, 0), 60, 1),
+		sample(at(today, 8, 0), 70, 1),
+	} {
+		err = s.Append(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// three days later the old raw segment is downsampled into 5 minute
+	// buckets, skipping missing values
+	//
+	err = s.Compact(at(old, 24*3+12, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists(s, tierRaw, old) {
+		t.Errorf("raw segment for %s was kept", old.Format(segmentDateFormat))
+	}
+	aggregates := readTier(t, s, tierAggregate, old)
+	if len(aggregates) != 3 {
+		t.Fatalf("got %d aggregates, want 3", len(aggregates))
+	}
+	checkAggregate(t, aggregates[0], at(old, 10, 0), 3, 10, 20, 30, 150)
+	checkAggregate(t, aggregates[1], at(old, 10, 5), 1, 40, 40, 40, nan)
+	checkAggregate(t, aggregates[2], at(old, 11, 0), 1, 50, 50, 50, 300)
+
+	// a week later the aggregates are downsampled into hours, weighting the
+	// means by count, while the recent raw segments are left alone
+	//
+	err = s.Compact(at(today, 12, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists(s, tierAggregate, old) {
+		t.Errorf("aggregate segment for %s was kept", old.Format(segmentDateFormat))
+	}
+	hourly := readTier(t, s, tierHourly, old)
+	if len(hourly) != 2 {
+		t.Fatalf("got %d hourly aggregates, want 2", len(hourly))
+	}
+	checkAggregate(t, hourly[0], at(old, 10, 0), 4, 10, 25, 40, 150)
+	checkAggregate(t, hourly[1], at(old, 11, 0), 1, 50, 50, 50, 300)
+	if !exists(s, tierRaw, yesterday) || !exists(s, tierRaw, today) {
+		t.Errorf("recent raw segments were compacted")
+	}
+
+	// the segment still being appended to is never compacted
+	//
+	err = s.Compact(at(today, 24*30, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists(s, tierRaw, yesterday) || !exists(s, tierRaw, today) {
+		t.Errorf("got yesterday %v and today %v, want only today's raw segment", exists(s, tierRaw, yesterday), exists(s, tierRaw, today))
+	}
+	err = s.Append(sample(at(today, 9, 0), 80, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every tier can still be read back
+	//
+	measurements, err := s.Range(old, today.AddDate(0, 0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float64{25, 50, 60, 70, 80}
+	if len(measurements) != len(want) {
+		t.Fatalf("got %d measurements, want %d", len(measurements), len(want))
+	}
+	for i, m := range measurements {
+		if m.PM2p5 != want[i] {
+			t.Errorf("measurement %d pm2p5 is %v, want %v", i, m.PM2p5, want[i])
+		}
+	}
+	if !measurements[0].Time.Equal(at(old, 10, 0)) {
+		t.Errorf("first measurement is at %s, want the start of its hour", measurements[0].Time)
+	}
+}
+
+func TestStoreQuery(t *testing.T) {
+	s, err := OpenStore(t.TempDir(), Retention{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+
+	start := time.Date(2026, 10, 17, 12, 0, 0, 0, time.Local)
+	for i, nox := range []float64{math.NaN(), 10, 20} {
+		err = s.Append(sample(start.Add(time.Duration(i)*time.Minute), float64(i), nox))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// from is inclusive and to exclusive
+	//
+	times, values, err := s.Query("nox", start, start.Add(2*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(times) != 2 || len(values) != 2 {
+		t.Fatalf("got %d times and %d values, want 2", len(times), len(values))
+	}
+	if !times[0].Equal(start) || !times[1].Equal(start.Add(time.Minute)) {
+		t.Errorf("times are %v", times)
+	}
+	if !math.IsNaN(values[0]) || values[1] != 10 {
+		t.Errorf("values are %v, want [NaN 10]", values)
+	}
+
+	_, _, err = s.Query("co2", start, start.Add(time.Hour))
+	if err == nil {
+		t.Errorf("querying an unknown metric succeeded")
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Publisher publishes to a bucket on S3 or anything compatible with it,
+// such as MinIO
+//
+// Objects are addressed path style, endpoint/bucket/key, which every
+// implementation supports.  Aliases are server side copies, so the today
+// key is a real object that any static bucket website can serve.
+type S3Publisher struct {
+	endpoint     *url.URL
+	bucket       string
+	region       string
+	prefix       string
+	accessKey    string
+	secretKey    string
+	cacheControl string
+	client       *http.Client
+}
+
+// NewS3Publisher returns a publisher that uploads into bucket at endpoint,
+// with prefix before every key
+func NewS3Publisher(endpoint string, bucket string, region string, prefix string, accessKey string, secretKey string, cacheControl string) (*S3Publisher, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("s3 endpoint %q must be an http or https URL", endpoint)
+	}
+	return &S3Publisher{
+		endpoint:     u,
+		bucket:       bucket,
+		region:       region,
+		prefix:       strings.Trim(prefix, "/"),
+		accessKey:    accessKey,
+		secretKey:    secretKey,
+		cacheControl: cacheControl,
+		client:       &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+func (p *S3Publisher) Publish(file string, name string) error {
+	body, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	req, err := p.request(http.MethodPut, name, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType(name))
+	if p.cacheControl != "" {
+		req.Header.Set("Cache-Control", p.cacheControl)
+	}
+	return p.do(req, "put", name)
+}
+
+func (p *S3Publisher) Alias(alias string, name string) error {
+	req, err := p.request(http.MethodPut, alias, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Amz-Copy-Source", (&url.URL{Path: "/" + p.bucket + "/" + p.key(name)}).EscapedPath())
+	req.Header.Set("X-Amz-Metadata-Directive", "REPLACE")
+	req.Header.Set("Content-Type", contentType(alias))
+	if p.cacheControl != "" {
+		req.Header.Set("Cache-Control", p.cacheControl)
+	}
+	return p.do(req, "copy", alias)
+}
+
+func (p *S3Publisher) Close() error {
+	return nil
+}
+
+// key returns the object key for a name
+func (p *S3Publisher) key(name string) string {
+	if p.prefix == "" {
+		return name
+	}
+	return p.prefix + "/" + name
+}
+
+// request returns a request for the object called name
+func (p *S3Publisher) request(method string, name string, body []byte) (*http.Request, error) {
+	// escape the path ourselves, as signature version 4 escapes more than
+	// net/url does
+	//
+	u := *p.endpoint
+	u.Path = strings.TrimRight(u.Path, "/") + "/" + p.bucket + "/" + p.key(name)
+	segments := strings.Split(u.Path, "/")
+	for i := range segments {
+		segments[i] = awsEscape(segments[i])
+	}
+	u.RawPath = strings.Join(segments, "/")
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(sum[:]))
+	return req, nil
+}
+
+// do signs and sends a request
+func (p *S3Publisher) do(req *http.Request, op string, name string) error {
+	signV4(req, p.accessKey, p.secretKey, p.region, "s3", time.Now())
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return err
+	}
+
+	// a copy can fail after the 200 status has been sent
+	//
+	var s3Err struct {
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	xml.Unmarshal(body, &s3Err)
+	if resp.StatusCode/100 != 2 || s3Err.XMLName.Local == "Error" {
+		if s3Err.Code != "" {
+			return fmt.Errorf("s3 %s %s: %s: %s", op, p.key(name), s3Err.Code, s3Err.Message)
+		}
+		return fmt.Errorf("s3 %s %s: %s", op, p.key(name), resp.Status)
+	}
+	return nil
+}
+
+// contentType returns the MIME type for a file name
+func contentType(name string) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return "application/octet-stream"
+}
+
+// signV4 signs a request with AWS signature version 4, signing the host
+// and every header set on the request
+//
+// The request must already have its X-Amz-Content-Sha256 header.
+func signV4(req *http.Request, accessKey string, secretKey string, region string, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		if name != "Authorization" {
+			headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalQuery returns the query string sorted and encoded as signature
+// version 4 wants it
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, awsEscape(name)+"="+awsEscape(value))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// awsEscape percent encodes everything but the unreserved characters
+func awsEscape(s string) string {
+	return strings.ReplaceAll(url.QueryEscape(s), "+", "%20")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Scheduler runs jobs periodically
+//
+// Each job has its own ticker and goroutine, so a slow job (eg an upload)
+// never delays the others and the intervals don't drift.  If a job is
+// still running when it is next due, that tick is skipped.
+type Scheduler struct {
+	jobs []*job
+}
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      func()
+	reset    chan time.Duration
+
+	// closed once the job has first run
+	started chan struct{}
+
+	// held while the job runs, so Pause can wait for it
+	mu sync.Mutex
+}
+
+// Every adds a job to run at the given interval
+func (s *Scheduler) Every(name string, interval time.Duration, run func()) {
+	s.jobs = append(s.jobs, &job{name: name, interval: interval, run: run, reset: make(chan time.Duration, 1), started: make(chan struct{})})
+}
+
+// SetInterval changes how often a job runs, counting from now
+func (s *Scheduler) SetInterval(name string, interval time.Duration) {
+	for _, j := range s.jobs {
+		if j.name != name {
+			continue
+		}
+		// replace any change the job has not picked up yet
+		select {
+		case <-j.reset:
+		default:
+		}
+		j.reset <- interval
+	}
+}
+
+// Pause waits for the named jobs to finish, in the order given, and holds
+// them back until resume is called
+//
+// Other jobs keep running.  Name a slow job first so the others are only
+// held back once it has finished.
+func (s *Scheduler) Pause(names ...string) (resume func()) {
+	var paused []*job
+	for _, name := range names {
+		for _, j := range s.jobs {
+			if j.name == name {
+				j.mu.Lock()
+				paused = append(paused, j)
+			}
+		}
+	}
+	return func() {
+		for _, j := range paused {
+			j.mu.Unlock()
+		}
+	}
+}
+
+// runJob runs a job, waiting while it is paused
+func (s *Scheduler) runJob(j *job) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.run()
+}
+
+// Run starts every job at once and runs them at their own intervals until
+// ctx is done.  It returns once running jobs finish.
+//
+// A job's first run waits for the first run of the job added before it,
+// so eg the first publish has a sample, but a job never holds back those
+// added before it.
+func (s *Scheduler) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	var previous chan struct{}
+	for _, j := range s.jobs {
+		wg.Add(1)
+		go func(j *job, previous chan struct{}) {
+			defer wg.Done()
+			if previous != nil {
+				select {
+				case <-ctx.Done():
+					return
+				case <-previous:
+				}
+			}
+			ticker := time.NewTicker(j.interval)
+			defer ticker.Stop()
+			if ctx.Err() != nil {
+				return
+			}
+			s.runJob(j)
+			close(j.started)
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case interval := <-j.reset:
+					if interval != j.interval {
+						j.interval = interval
+						ticker.Reset(interval)
+					}
+				case <-ticker.C:
+					s.runJob(j)
+				}
+			}
+		}(j, previous)
+		previous = j.started
+	}
+	wg.Wait()
+}
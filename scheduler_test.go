@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// counter counts a job's runs and records the order jobs first ran in
+type counter struct {
+	mu    sync.Mutex
+	runs  map[string]int
+	order []string
+}
+
+func (c *counter) job(name string, sleep time.Duration) func() {
+	return func() {
+		c.mu.Lock()
+		if c.runs[name] == 0 {
+			c.order = append(c.order, name)
+		}
+		c.runs[name]++
+		c.mu.Unlock()
+		time.Sleep(sleep)
+	}
+}
+
+func (c *counter) count(name string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.runs[name]
+}
+
+func TestSchedulerSlowJob(t *testing.T) {
+	c := &counter{runs: map[string]int{}}
+	var s Scheduler
+	s.Every("sample", 10*time.Millisecond, c.job("sample", 0))
+	s.Every("upload", 10*time.Millisecond, c.job("upload", 300*time.Millisecond))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	s.Run(ctx)
+
+	// sample keeps running while the first upload is
+	if n := c.count("sample"); n < 5 {
+		t.Errorf("sample ran %d times while upload was running, want at least 5", n)
+	}
+}
+
+func TestSchedulerFirstRunOrder(t *testing.T) {
+	c := &counter{runs: map[string]int{}}
+	var s Scheduler
+	for _, name := range []string{"sample", "publish", "render", "upload"} {
+		s.Every(name, time.Hour, c.job(name, 10*time.Millisecond))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	s.Run(ctx)
+
+	want := []string{"sample", "publish", "render", "upload"}
+	if len(c.order) != len(want) {
+		t.Fatalf("jobs first ran in order %v, want %v", c.order, want)
+	}
+	for i := range want {
+		if c.order[i] != want[i] {
+			t.Fatalf("jobs first ran in order %v, want %v", c.order, want)
+		}
+	}
+}
+
+func TestSchedulerPause(t *testing.T) {
+	c := &counter{runs: map[string]int{}}
+	var s Scheduler
+	s.Every("sample", 10*time.Millisecond, c.job("sample", 0))
+	s.Every("upload", 10*time.Millisecond, c.job("upload", 100*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	// pausing waits for the running upload, then holds it back, while
+	// sample keeps running throughout
+	//
+	time.Sleep(20 * time.Millisecond)
+	resume := s.Pause("upload")
+	uploads := c.count("upload")
+	samples := c.count("sample")
+	time.Sleep(150 * time.Millisecond)
+	if n := c.count("upload"); n != uploads {
+		t.Errorf("upload ran %d times while paused", n-uploads)
+	}
+	if n := c.count("sample") - samples; n < 5 {
+		t.Errorf("sample ran %d times while upload was paused, want at least 5", n)
+	}
+	resume()
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// SEN5x I2C commands
+const (
+	sen5xStartMeasurement   = 0x0021
+	sen5xStopMeasurement    = 0x0104
+	sen5xReadMeasuredValues = 0x03C4
+	sen5xGetProductName     = 0xD014
+	sen5xGetSerialNumber    = 0xD033
+	sen5xGetFirmwareVersion = 0xD100
+	sen5xReadDeviceStatus   = 0xD206
+	sen5xDeviceReset        = 0xD304
+)
+
+// SEN5x is a Sensirion SEN50/SEN54/SEN55 sensor
+//
+// It speaks the SEN5x I2C command set directly: every command is a 16 bit
+// big endian word and every 16 bit word read back is followed by a CRC8.
+type SEN5x struct {
+	bus Bus
+}
+
+// NewSEN5x returns a SEN5x sensor on the given bus
+func NewSEN5x(bus Bus) *SEN5x {
+	return &SEN5x{bus: bus}
+}
+
+func (s *SEN5x) Init() error {
+	err := s.command(sen5xDeviceReset, 200*time.Millisecond)
+	if err != nil {
+		return fmt.Errorf("sen5x device reset error=%v", err)
+	}
+	return nil
+}
+
+func (s *SEN5x) Info() (SensorInfo, error) {
+	var info SensorInfo
+
+	serialNumber, err := s.readString(sen5xGetSerialNumber)
+	if err != nil {
+		return info, fmt.Errorf("sen5x get serial number error=%v", err)
+	}
+	info.SerialNumber = serialNumber
+
+	productName, err := s.readString(sen5xGetProductName)
+	if err != nil {
+		return info, fmt.Errorf("sen5x get product name error=%v", err)
+	}
+	info.ProductName = productName
+
+	words, err := s.read(sen5xGetFirmwareVersion, 20*time.Millisecond, 1)
+	if err != nil {
+		return info, fmt.Errorf("sen5x get firmware version error=%v", err)
+	}
+	info.FirmwareVersion = fmt.Sprintf("%d", words[0]>>8)
+
+	return info, nil
+}
+
+func (s *SEN5x) Start() error {
+	err := s.command(sen5xStartMeasurement, 50*time.Millisecond)
+	if err != nil {
+		return fmt.Errorf("sen5x start measurement error=%v", err)
+	}
+	return nil
+}
+
+func (s *SEN5x) Read() (Measurement, error) {
+	words, err := s.read(sen5xReadMeasuredValues, 20*time.Millisecond, 8)
+	if err != nil {
+		return Measurement{}, fmt.Errorf("sen5x read measured values error=%v", err)
+	}
+
+	// unsigned values use 0xffff and signed values 0x7fff for "not available"
+	//
+	unsigned := func(w uint16, scale float64) float64 {
+		if w == 0xffff {
+			return math.NaN()
+		}
+		return float64(w) / scale
+	}
+	signed := func(w uint16, scale float64) float64 {
+		if w == 0x7fff {
+			return math.NaN()
+		}
+		return float64(int16(w)) / scale
+	}
+
+	return Measurement{
+		Time:        time.Now(),
+		PM1p0:       unsigned(words[0], 10),
+		PM2p5:       unsigned(words[1], 10),
+		PM4p0:       unsigned(words[2], 10),
+		PM10p0:      unsigned(words[3], 10),
+		Humidity:    signed(words[4], 100),
+		Temperature: signed(words[5], 200),
+		VOC:         signed(words[6], 10),
+		NOx:         signed(words[7], 10),
+	}, nil
+}
+
+// Stop stops measuring and closes the bus
+func (s *SEN5x) Stop() error {
+	err := s.command(sen5xStopMeasurement, 200*time.Millisecond)
+	closeErr := s.bus.Close()
+	if err != nil {
+		return fmt.Errorf("sen5x stop measurement error=%v", err)
+	}
+	return closeErr
+}
+
+// Status returns the device status register, zero if all is well
+func (s *SEN5x) Status() (uint32, error) {
+	words, err := s.read(sen5xReadDeviceStatus, 20*time.Millisecond, 2)
+	if err != nil {
+		return 0, fmt.Errorf("sen5x read device status error=%v", err)
+	}
+	return uint32(words[0])<<16 | uint32(words[1]), nil
+}
+
+// command sends a command and waits for it to execute
+func (s *SEN5x) command(cmd uint16, delay time.Duration) error {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], cmd)
+	err := s.bus.Write(buf[:])
+	if err != nil {
+		return err
+	}
+	time.Sleep(delay)
+	return nil
+}
+
+// read sends a command then reads back count CRC checked words
+func (s *SEN5x) read(cmd uint16, delay time.Duration, count int) ([]uint16, error) {
+	err := s.command(cmd, delay)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, count*3)
+	err = s.bus.Read(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	words := make([]uint16, count)
+	for i := range words {
+		chunk := buf[i*3 : i*3+3]
+		if crc8(chunk[:2]) != chunk[2] {
+			return nil, fmt.Errorf("crc mismatch in word %d", i)
+		}
+		words[i] = binary.BigEndian.Uint16(chunk[:2])
+	}
+	return words, nil
+}
+
+// readString reads a 32 byte NUL terminated string
+func (s *SEN5x) readString(cmd uint16) (string, error) {
+	words, err := s.read(cmd, 50*time.Millisecond, 16)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, 0, 32)
+	for _, w := range words {
+		b = append(b, byte(w>>8), byte(w))
+	}
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b), nil
+}
+
+// crc8 is the Sensirion CRC-8 (polynomial 0x31, initial value 0xff)
+func crc8(data []byte) byte {
+	crc := byte(0xff)
+	for _, b := range data {
+		crc ^= b
+		for i := 0; i < 8; i++ {
+			if crc&0x80 != 0 {
+				crc = crc<<1 ^ 0x31
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// fakeBus answers each command with canned words, adding their CRCs
+type fakeBus struct {
+	responses map[uint16][]byte
+	last      uint16
+	closed    bool
+}
+
+func newFakeBus() *fakeBus {
+	return &fakeBus{responses: map[uint16][]byte{}}
+}
+
+// respond sets the words read back after cmd
+func (b *fakeBus) respond(cmd uint16, words ...uint16) {
+	var data []byte
+	for _, w := range words {
+		chunk := []byte{byte(w >> 8), byte(w)}
+		data = append(data, chunk[0], chunk[1], crc8(chunk))
+	}
+	b.responses[cmd] = data
+}
+
+func (b *fakeBus) Write(data []byte) error {
+	b.last = binary.BigEndian.Uint16(data)
+	return nil
+}
+
+func (b *fakeBus) Read(data []byte) error {
+	copy(data, b.responses[b.last])
+	return nil
+}
+
+func (b *fakeBus) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestCRC8(t *testing.T) {
+	if crc := crc8([]byte{0xbe, 0xef}); crc != 0x92 {
+		t.Errorf("crc8(0xbeef) = 0x%02x, want 0x92", crc)
+	}
+}
+
+func TestSEN5xRead(t *testing.T) {
+	bus := newFakeBus()
+	bus.respond(sen5xReadMeasuredValues, 123, 0xffff, 0, 1000, 4550, 0xfe70, 0x7fff, 10)
+	m, err := NewSEN5x(bus).Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"pm1p0", m.PM1p0, 12.3},
+		{"pm2p5", m.PM2p5, math.NaN()},
+		{"pm4p0", m.PM4p0, 0},
+		{"pm10p0", m.PM10p0, 100},
+		{"humidity", m.Humidity, 45.5},
+		{"temperature", m.Temperature, -2},
+		{"voc", m.VOC, math.NaN()},
+		{"nox", m.NOx, 1},
+	} {
+		if math.IsNaN(test.want) {
+			if !math.IsNaN(test.got) {
+				t.Errorf("%s = %v, want NaN", test.name, test.got)
+			}
+		} else if math.Abs(test.got-test.want) > 1e-9 {
+			t.Errorf("%s = %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestSEN5xCRCMismatch(t *testing.T) {
+	bus := newFakeBus()
+	bus.respond(sen5xReadMeasuredValues, 1, 2, 3, 4, 5, 6, 7, 8)
+	bus.responses[sen5xReadMeasuredValues][3*2+2] ^= 0xff
+	_, err := NewSEN5x(bus).Read()
+	if err == nil || err.Error() != "sen5x read measured values error=crc mismatch in word 2" {
+		t.Errorf("got %v, want a crc mismatch in word 2", err)
+	}
+}
+
+func TestSEN5xInfo(t *testing.T) {
+	// strings are 32 bytes, NUL terminated and padded
+	//
+	words := func(s string) []uint16 {
+		b := make([]byte, 32)
+		copy(b, s)
+		b[len(s)+1] = 'x'
+		w := make([]uint16, 16)
+		for i := range w {
+			w[i] = binary.BigEndian.Uint16(b[i*2:])
+		}
+		return w
+	}
+	bus := newFakeBus()
+	bus.respond(sen5xGetSerialNumber, words("1234ABCD")...)
+	bus.respond(sen5xGetProductName, words("SEN55")...)
+	bus.respond(sen5xGetFirmwareVersion, 0x0200)
+
+	info, err := NewSEN5x(bus).Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.SerialNumber != "1234ABCD" || info.ProductName != "SEN55" || info.FirmwareVersion != "2" {
+		t.Errorf("got %+v", info)
+	}
+}
+
+func TestSEN5xStopClosesBus(t *testing.T) {
+	bus := newFakeBus()
+	err := NewSEN5x(bus).Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bus.last != sen5xStopMeasurement {
+		t.Errorf("last command 0x%04x, want stop measurement", bus.last)
+	}
+	if !bus.closed {
+		t.Error("bus not closed")
+	}
+}
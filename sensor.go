@@ -0,0 +1,82 @@
+package main
+
+import (
+	"time"
+)
+
+// Measurement is one set of readings taken from a sensor
+type Measurement struct {
+	Time        time.Time
+	PM1p0       float64 // µg/m³
+	PM2p5       float64 // µg/m³
+	PM4p0       float64 // µg/m³
+	PM10p0      float64 // µg/m³
+	Humidity    float64 // %RH
+	Temperature float64 // °C
+	VOC         float64 // index
+	NOx         float64 // index, NaN while the sensor warms up
+}
+
+// Value returns the named metric, eg "pm2p5"
+func (m Measurement) Value(key string) (float64, error) {
+	metric, err := findMetric(key)
+	if err != nil {
+		return 0, err
+	}
+	return *metric.Field(&m), nil
+}
+
+// values returns pointers to every metric, in the order of the metrics table
+func (m *Measurement) values() []*float64 {
+	values := make([]*float64, len(metrics))
+	for i := range metrics {
+		values[i] = metrics[i].Field(m)
+	}
+	return values
+}
+
+// SensorInfo identifies the sensor hardware
+type SensorInfo struct {
+	SerialNumber    string
+	ProductName     string
+	FirmwareVersion string
+}
+
+// Sensor is an air quality sensor
+//
+// The expected life cycle is Init, Info, Start, any number of Reads and
+// finally Stop.
+type Sensor interface {
+	// Init prepares the sensor, resetting it if needed
+	Init() error
+	// Info returns the sensor identification
+	Info() (SensorInfo, error)
+	// Start begins measuring
+	Start() error
+	// Read returns the latest measurement
+	Read() (Measurement, error)
+	// Stop ends measuring
+	Stop() error
+}
+
+// StatusSensor is a Sensor that can also report its device status register
+type StatusSensor interface {
+	Sensor
+	Status() (uint32, error)
+}
+
+// newSensor returns the sensor selected by the options
+func newSensor() (Sensor, error) {
+	switch options.Sensor {
+	case "sim":
+		return NewSimSensor(options.Seed), nil
+	case "replay":
+		return NewReplaySensor(options.ReplayFile, options.ReplaySpeed), nil
+	default:
+		bus, err := OpenI2C(options.I2C.Device, options.I2C.Address)
+		if err != nil {
+			return nil, err
+		}
+		return NewSEN5x(bus), nil
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// DashboardServer serves a live dashboard of today's readings and charts
+//
+// Charts are rendered from the history when they are asked for, so a
+// single Pi can show its own graphs without a publisher or a separate web
+// server.  The page is the one published to the upload directory, and
+// /state.json has the latest measurement for anything else that wants it.
+type DashboardServer struct {
+	history *History
+	server  *http.Server
+}
+
+// NewDashboardServer returns a server for the dashboard on addr
+func NewDashboardServer(addr string, history *History) *DashboardServer {
+	s := &DashboardServer{history: history}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.index)
+	mux.HandleFunc("/charts/", s.chart)
+	mux.HandleFunc("/state.json", s.state)
+	s.server = &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	return s
+}
+
+// Start listens on the server's address and serves in the background
+func (s *DashboardServer) Start() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		err := s.server.Serve(listener)
+		if err != http.ErrServerClosed {
+			fmt.Printf("http error=%v\n", err)
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops the server, waiting for requests in progress until ctx is
+// done
+func (s *DashboardServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+func (s *DashboardServer) index(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	optionsMu.RLock()
+	dashboard := &Dashboard{Title: options.HomeAssistant.DeviceName, Day: startOfDay(time.Now())}
+	if m, ok := s.history.Latest(); ok {
+		dashboard = NewDashboard(startOfDay(m.Time), m)
+	}
+	dashboard.Refresh = int(options.HTTP.Refresh / time.Second)
+	optionsMu.RUnlock()
+
+	// a chart needs at least two readings
+	//
+	if xaxis, _ := s.history.Series(metrics[0].Key); len(xaxis) >= 2 {
+		for _, metric := range metrics {
+			dashboard.Charts = append(dashboard.Charts, DashboardChart{Name: metric.Name, Src: "charts/" + metric.Key + ".png"})
+		}
+	}
+
+	var page bytes.Buffer
+	err := dashboard.Render(&page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(page.Bytes())
+}
+
+func (s *DashboardServer) chart(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/charts/")
+	if !strings.HasSuffix(name, ".png") {
+		http.NotFound(w, r)
+		return
+	}
+	metric, err := findMetric(strings.TrimSuffix(name, ".png"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	xaxis, values := s.history.Series(metric.Key)
+	if len(xaxis) < 2 {
+		http.Error(w, "not enough readings for a chart yet", http.StatusNotFound)
+		return
+	}
+
+	var png bytes.Buffer
+	optionsMu.RLock()
+	err = writeChart(&png, metric, xaxis, values)
+	optionsMu.RUnlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(png.Bytes())
+}
+
+func (s *DashboardServer) state(w http.ResponseWriter, r *http.Request) {
+	m, ok := s.history.Latest()
+	if !ok {
+		http.Error(w, "no readings yet", http.StatusNotFound)
+		return
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(data)
+}
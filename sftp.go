@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpAttempts is how many times an upload is tried before giving up
+const sftpAttempts = 3
+
+// SFTPPublisher publishes to a remote directory over SFTP
+//
+// One ssh connection is kept open between uploads and only remade when it
+// fails.  The host key must be in known_hosts, and we authenticate with the
+// ssh agent, if there is one, and the given private keys.
+type SFTPPublisher struct {
+	addr   string
+	dir    string
+	config *ssh.ClientConfig
+
+	conn   *ssh.Client
+	client *sftp.Client
+}
+
+// NewSFTPPublisher returns a publisher that uploads into dir on host, which
+// may include a port
+func NewSFTPPublisher(host string, user string, keys []string, knownHostsFile string, dir string) (*SFTPPublisher, error) {
+	hostKeyCallback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var signers []ssh.Signer
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		conn, err := net.Dial("unix", socket)
+		if err == nil {
+			agentSigners, err := agent.NewClient(conn).Signers()
+			if err == nil {
+				signers = append(signers, agentSigners...)
+			}
+		}
+	}
+	for _, key := range keys {
+		data, err := os.ReadFile(key)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) == 0 {
+		return nil, fmt.Errorf("no ssh keys, give upload.key or run an ssh agent")
+	}
+
+	addr := host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		addr = net.JoinHostPort(host, "22")
+	}
+	return &SFTPPublisher{
+		addr: addr,
+		dir:  dir,
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signers...)},
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+	}, nil
+}
+
+// defaultSSHKeys returns the usual private keys in ~/.ssh that exist
+func defaultSSHKeys() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	var keys []string
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		key := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(key); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (p *SFTPPublisher) Publish(file string, name string) error {
+	dst := path.Join(p.dir, name)
+	tmp := path.Join(path.Dir(dst), "."+path.Base(dst)+".tmp")
+	return p.retry(func(c *sftp.Client) error {
+		src, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		err = c.MkdirAll(path.Dir(dst))
+		if err != nil {
+			return err
+		}
+		f, err := c.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, src)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		err = f.Close()
+		if err != nil {
+			return err
+		}
+		err = c.Chmod(tmp, 0644)
+		if err != nil {
+			return err
+		}
+		return replace(c, tmp, dst)
+	})
+}
+
+func (p *SFTPPublisher) Alias(alias string, name string) error {
+	link := path.Join(p.dir, alias)
+	target := path.Join(p.dir, name)
+	if path.Dir(link) == path.Dir(target) {
+		target = path.Base(target)
+	}
+	tmp := path.Join(path.Dir(link), "."+path.Base(link)+".tmp")
+	return p.retry(func(c *sftp.Client) error {
+		c.Remove(tmp)
+		err := c.Symlink(target, tmp)
+		if err != nil {
+			return err
+		}
+		return replace(c, tmp, link)
+	})
+}
+
+// replace renames oldname over newname
+//
+// This is atomic with the posix-rename extension, which OpenSSH has.  A
+// plain SFTP rename fails if newname exists, so without the extension
+// newname is removed first and is briefly missing.
+func replace(c *sftp.Client, oldname string, newname string) error {
+	if _, ok := c.HasExtension("posix-rename@openssh.com"); ok {
+		return c.PosixRename(oldname, newname)
+	}
+	err := c.Rename(oldname, newname)
+	if err == nil {
+		return nil
+	}
+	c.Remove(newname)
+	return c.Rename(oldname, newname)
+}
+
+// retry runs op with the sftp client, reconnecting and trying again if the
+// connection fails
+//
+// Errors from the server, such as permission denied, and host key
+// mismatches are not retried.
+func (p *SFTPPublisher) retry(op func(c *sftp.Client) error) error {
+	var err error
+	for attempt := 1; attempt <= sftpAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(time.Duration(attempt-1) * time.Second)
+		}
+		if p.client == nil {
+			err = p.connect()
+			var keyErr *knownhosts.KeyError
+			if errors.As(err, &keyErr) {
+				return fmt.Errorf("%s: %v", p.addr, err)
+			}
+			if err != nil {
+				continue
+			}
+		}
+
+		err = op(p.client)
+		var statusErr *sftp.StatusError
+		if err == nil || errors.As(err, &statusErr) || errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) {
+			return err
+		}
+		p.Close()
+	}
+	return fmt.Errorf("%s: %v, after %d attempts", p.addr, err, sftpAttempts)
+}
+
+// connect opens the ssh connection and sftp session
+func (p *SFTPPublisher) connect() error {
+	conn, err := ssh.Dial("tcp", p.addr, p.config)
+
+	// known_hosts may have a different type of key to the one the server
+	// offers first, so ask for the type it has
+	//
+	var keyErr *knownhosts.KeyError
+	if errors.As(err, &keyErr) && len(keyErr.Want) > 0 && p.config.HostKeyAlgorithms == nil {
+		for _, want := range keyErr.Want {
+			if want.Key.Type() == ssh.KeyAlgoRSA {
+				p.config.HostKeyAlgorithms = append(p.config.HostKeyAlgorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+			}
+			p.config.HostKeyAlgorithms = append(p.config.HostKeyAlgorithms, want.Key.Type())
+		}
+		conn, err = ssh.Dial("tcp", p.addr, p.config)
+	}
+	if err != nil {
+		return err
+	}
+
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	p.conn = conn
+	p.client = client
+	return nil
+}
+
+func (p *SFTPPublisher) Close() error {
+	if p.client == nil {
+		return nil
+	}
+	p.client.Close()
+	err := p.conn.Close()
+	p.client = nil
+	p.conn = nil
+	return err
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sftpServer is an ssh server with the sftp subsystem, serving the local
+// file system
+type sftpServer struct {
+	addr    string
+	hostKey ssh.Signer
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+// dropConnections closes every connection to the server
+func (s *sftpServer) dropConnections() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func newSigner(t *testing.T) (ssh.Signer, ed25519.PrivateKey) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer, key
+}
+
+// startSFTPServer starts a server that only lets clientKey log in
+func startSFTPServer(t *testing.T, clientKey ssh.PublicKey) *sftpServer {
+	hostKey, _ := newSigner(t)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	s := &sftpServer{addr: listener.Addr().String(), hostKey: hostKey}
+	t.Cleanup(s.dropConnections)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, conn)
+			s.mu.Unlock()
+			go serveSFTP(conn, config)
+		}
+	}()
+	return s
+}
+
+func serveSFTP(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "session only")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					server, err := sftp.NewServer(channel)
+					if err == nil {
+						server.Serve()
+					}
+					channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+// newTestSFTPPublisher returns a publisher for a new server, uploading into
+// a temporary directory
+func newTestSFTPPublisher(t *testing.T) (*SFTPPublisher, *sftpServer, string) {
+	t.Setenv("SSH_AUTH_SOCK", "")
+	signer, key := newSigner(t)
+	server := startSFTPServer(t, signer.PublicKey())
+
+	tmp := t.TempDir()
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(tmp, "id_ed25519")
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	knownHostsFile := filepath.Join(tmp, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, server.hostKey.PublicKey())
+	err = os.WriteFile(knownHostsFile, []byte(line+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(tmp, "www")
+	p, err := NewSFTPPublisher(server.addr, "airquality", []string{keyFile}, knownHostsFile, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Close() })
+	return p, server, dir
+}
+
+func writeTemp(t *testing.T, content string) string {
+	file := filepath.Join(t.TempDir(), "chart.png")
+	err := os.WriteFile(file, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func checkFile(t *testing.T, path string, want string) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("%s is %q, want %q", path, got, want)
+	}
+}
+
+func TestSFTPPublishAndAlias(t *testing.T) {
+	p, _, dir := newTestSFTPPublisher(t)
+
+	// publish twice so the second replaces the first
+	//
+	for _, content := range []string{"first", "second"} {
+		err := p.Publish(writeTemp(t, content), "2026-10-17/pm2p5.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Alias("pm2p5-today.png", "2026-10-17/pm2p5.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkFile(t, filepath.Join(dir, "2026-10-17", "pm2p5.png"), "second")
+	checkFile(t, filepath.Join(dir, "pm2p5-today.png"), "second")
+
+	info, err := os.Stat(filepath.Join(dir, "2026-10-17", "pm2p5.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("mode is %04o, want 0644", info.Mode().Perm())
+	}
+	target, err := os.Readlink(filepath.Join(dir, "pm2p5-today.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != filepath.Join(dir, "2026-10-17", "pm2p5.png") {
+		t.Errorf("alias points at %s", target)
+	}
+
+	// an alias in the same directory is relative
+	//
+	err = p.Publish(writeTemp(t, "flat"), "pm2p5-2026-10-17.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Alias("pm2p5-today.png", "pm2p5-2026-10-17.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	target, err = os.Readlink(filepath.Join(dir, "pm2p5-today.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "pm2p5-2026-10-17.png" {
+		t.Errorf("alias points at %s", target)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".tmp") {
+			t.Errorf("temporary file %s left behind", entry.Name())
+		}
+	}
+}
+
+func TestSFTPReconnect(t *testing.T) {
+	p, server, dir := newTestSFTPPublisher(t)
+
+	err := p.Publish(writeTemp(t, "first"), "pm2p5.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.dropConnections()
+	err = p.Publish(writeTemp(t, "second"), "pm2p5.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFile(t, filepath.Join(dir, "pm2p5.png"), "second")
+}
+
+func TestSFTPHostKeyMismatch(t *testing.T) {
+	p, server, _ := newTestSFTPPublisher(t)
+
+	other, _ := newSigner(t)
+	knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(server.addr)}, other.PublicKey())
+	err := os.WriteFile(knownHostsFile, []byte(line+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.config.HostKeyCallback, err = knownhosts.New(knownHostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.Publish(writeTemp(t, "chart"), "pm2p5.png")
+	if err == nil || !strings.Contains(err.Error(), "key mismatch") || strings.Contains(err.Error(), "attempts") {
+		t.Fatalf("got %v, want a host key mismatch without retries", err)
+	}
+}
+
+func TestSFTPMissingFile(t *testing.T) {
+	p, _, _ := newTestSFTPPublisher(t)
+
+	err := p.Publish(filepath.Join(t.TempDir(), "missing.png"), "pm2p5.png")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("got %v, want a not exist error", err)
+	}
+}
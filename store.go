@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const segmentDateFormat = "2006-01-02"
+
+// Store is an on-disk history of every measurement
+//
+// Measurements are appended to one segment file per local day,
+// <dir>/YYYY-MM-DD.jsonl, in the same JSON lines format as a Recorder
+// so a segment can also be replayed with --sensor=replay.  Older days are
+// downsampled into the aggregate and hourly tiers by Compact.
+type Store struct {
+	dir       string
+	retention Retention
+	mu        sync.Mutex
+	segment   *Recorder
+	day       string
+}
+
+// OpenStore opens (creating if needed) the store in dir
+func OpenStore(dir string, retention Retention) (*Store, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir, retention: retention}, nil
+}
+
+func (s *Store) segmentPath(tier string, day string) string {
+	return filepath.Join(s.dir, tier, day+".jsonl")
+}
+
+// Append adds a measurement to the segment for its day
+func (s *Store) Append(m Measurement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	day := m.Time.Local().Format(segmentDateFormat)
+	if s.segment == nil || day != s.day {
+		if s.segment != nil {
+			s.segment.Close()
+			s.segment = nil
+		}
+		segment, err := NewRecorder(s.segmentPath(tierRaw, day))
+		if err != nil {
+			return err
+		}
+		s.segment = segment
+		s.day = day
+	}
+	return s.segment.Write(m)
+}
+
+// Aggregates returns the stored history with from <= time < to, oldest first
+//
+// Raw samples are returned as aggregates with a count of one.  Each day
+// comes from the most detailed tier that has it.
+func (s *Store) Aggregates(from, to time.Time) ([]Aggregate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var result []Aggregate
+	for day := startOfDay(from); day.Before(to); day = day.AddDate(0, 0, 1) {
+		for _, tier := range []string{tierRaw, tierAggregate, tierHourly} {
+			aggregates, err := readAggregates(s.segmentPath(tier, day.Format(segmentDateFormat)), tier)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range aggregates {
+				if !a.Time.Before(from) && a.Time.Before(to) {
+					result = append(result, a)
+				}
+			}
+			break
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Time.Before(result[j].Time) })
+	return result, nil
+}
+
+// Range returns the stored measurements with from <= time < to, oldest first
+//
+// Downsampled history is returned as the mean of each bucket.
+func (s *Store) Range(from, to time.Time) ([]Measurement, error) {
+	aggregates, err := s.Aggregates(from, to)
+	if err != nil {
+		return nil, err
+	}
+	measurements := make([]Measurement, len(aggregates))
+	for i, a := range aggregates {
+		measurements[i] = a.Mean
+		measurements[i].Time = a.Time
+	}
+	return measurements, nil
+}
+
+// Query returns the times and values of one metric with from <= time < to
+func (s *Store) Query(metric string, from, to time.Time) ([]time.Time, []float64, error) {
+	measurements, err := s.Range(from, to)
+	if err != nil {
+		return nil, nil, err
+	}
+	times := make([]time.Time, 0, len(measurements))
+	values := make([]float64, 0, len(measurements))
+	for _, m := range measurements {
+		v, err := m.Value(metric)
+		if err != nil {
+			return nil, nil, err
+		}
+		times = append(times, m.Time)
+		values = append(values, v)
+	}
+	return times, values, nil
+}
+
+// Days returns every day with history in any tier, oldest first
+func (s *Store) Days() ([]time.Time, error) {
+	seen := map[time.Time]bool{}
+	var days []time.Time
+	for _, tier := range []string{tierRaw, tierAggregate, tierHourly} {
+		tierDays, err := s.segmentDays(tier)
+		if err != nil {
+			return nil, err
+		}
+		for _, day := range tierDays {
+			if !seen[day] {
+				seen[day] = true
+				days = append(days, day)
+			}
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+	return days, nil
+}
+
+// Close flushes and closes the current segment
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.segment == nil {
+		return nil
+	}
+	err := s.segment.Close()
+	s.segment = nil
+	return err
+}
+
+// startOfDay returns local midnight at the start of t's day
+func startOfDay(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
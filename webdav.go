@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// WebDAVPublisher publishes to a WebDAV collection, such as a Nextcloud
+// share
+//
+// Missing collections are made with MKCOL, and aliases are server side
+// COPYs, falling back to uploading the file again for servers without
+// COPY.
+type WebDAVPublisher struct {
+	root     *url.URL
+	user     string
+	password string
+	client   *http.Client
+
+	// collections known to exist, and the local file last published as
+	// each name
+	collections map[string]bool
+	files       map[string]string
+}
+
+// NewWebDAVPublisher returns a publisher that uploads below the collection
+// at root
+func NewWebDAVPublisher(root string, user string, password string) (*WebDAVPublisher, error) {
+	u, err := url.Parse(strings.TrimRight(root, "/"))
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("webdav url %q must be an http or https URL", root)
+	}
+	return &WebDAVPublisher{
+		root:        u,
+		user:        user,
+		password:    password,
+		client:      &http.Client{Timeout: 30 * time.Second},
+		collections: map[string]bool{},
+		files:       map[string]string{},
+	}, nil
+}
+
+func (p *WebDAVPublisher) Publish(file string, name string) error {
+	err := p.mkcols(path.Dir(name))
+	if err != nil {
+		return err
+	}
+	err = p.put(file, name)
+	if err != nil {
+		return err
+	}
+	p.files[name] = file
+	return nil
+}
+
+func (p *WebDAVPublisher) Alias(alias string, name string) error {
+	err := p.mkcols(path.Dir(alias))
+	if err != nil {
+		return err
+	}
+
+	req, err := p.request("COPY", name, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Destination", p.url(alias))
+	req.Header.Set("Overwrite", "T")
+	status, err := p.do(req, "copy", name)
+	if err == nil {
+		return nil
+	}
+	if status != http.StatusMethodNotAllowed && status != http.StatusNotImplemented {
+		return err
+	}
+
+	// no COPY, upload it again
+	//
+	file, ok := p.files[name]
+	if !ok {
+		return err
+	}
+	return p.put(file, alias)
+}
+
+func (p *WebDAVPublisher) Close() error {
+	return nil
+}
+
+// put uploads a local file as name
+func (p *WebDAVPublisher) put(file string, name string) error {
+	body, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	req, err := p.request(http.MethodPut, name, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType(name))
+	_, err = p.do(req, "put", name)
+	return err
+}
+
+// mkcols makes the collection dir, and any parents, unless they are known
+// to exist
+func (p *WebDAVPublisher) mkcols(dir string) error {
+	if dir == "." || dir == "/" || dir == "" || p.collections[dir] {
+		return nil
+	}
+	err := p.mkcols(path.Dir(dir))
+	if err != nil {
+		return err
+	}
+
+	req, err := p.request("MKCOL", dir+"/", nil)
+	if err != nil {
+		return err
+	}
+	status, err := p.do(req, "mkcol", dir)
+
+	// 405 means it already exists
+	//
+	if err != nil && status != http.StatusMethodNotAllowed {
+		return err
+	}
+	p.collections[dir] = true
+	return nil
+}
+
+// url returns the URL of name
+func (p *WebDAVPublisher) url(name string) string {
+	u := *p.root
+	u.Path = p.root.Path + "/" + strings.TrimLeft(name, "/")
+	u.RawPath = ""
+	return u.String()
+}
+
+func (p *WebDAVPublisher) request(method string, name string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequest(method, p.url(name), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if p.user != "" {
+		req.SetBasicAuth(p.user, p.password)
+	}
+	return req, nil
+}
+
+// do sends a request, returning the status and an error if it is not a
+// success
+func (p *WebDAVPublisher) do(req *http.Request, op string, name string) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("webdav %s %s: %s", op, name, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
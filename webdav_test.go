@@ -0,0 +1,189 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// webdavRequest is what the stand in server received
+type webdavRequest struct {
+	method string
+	path   string
+	header http.Header
+	body   string
+	user   string
+}
+
+func (r webdavRequest) String() string {
+	return r.method + " " + r.path
+}
+
+// newWebDAVStandIn starts a server that records requests and answers with
+// the status given by status
+func newWebDAVStandIn(t *testing.T, status func(method string, path string) int) (*httptest.Server, func() []webdavRequest) {
+	var mu sync.Mutex
+	var requests []webdavRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		user, password, _ := r.BasicAuth()
+		mu.Lock()
+		requests = append(requests, webdavRequest{r.Method, r.URL.EscapedPath(), r.Header, string(data), user + ":" + password})
+		mu.Unlock()
+		w.WriteHeader(status(r.Method, r.URL.EscapedPath()))
+	}))
+	t.Cleanup(server.Close)
+	return server, func() []webdavRequest {
+		mu.Lock()
+		defer mu.Unlock()
+		return requests
+	}
+}
+
+func checkRequests(t *testing.T, got []webdavRequest, want ...string) {
+	t.Helper()
+	var methods []string
+	for _, r := range got {
+		methods = append(methods, r.String())
+	}
+	if strings.Join(methods, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got requests\n%s\nwant\n%s", strings.Join(methods, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestWebDAVPublisher(t *testing.T) {
+	server, requests := newWebDAVStandIn(t, func(method string, path string) int {
+		if method == "MKCOL" && path == "/dav/air/charts/" {
+			// already exists
+			return http.StatusMethodNotAllowed
+		}
+		return http.StatusCreated
+	})
+	p, err := NewWebDAVPublisher(server.URL+"/dav/air/", "airquality", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = p.Publish(writeTemp(t, "png"), "charts/2026-10-17/pm2p5.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Alias("charts/pm2p5-today.png", "charts/2026-10-17/pm2p5.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the collections are now known to exist
+	//
+	err = p.Publish(writeTemp(t, "png"), "charts/2026-10-17/pm10p0.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := requests()
+	checkRequests(t, got,
+		"MKCOL /dav/air/charts/",
+		"MKCOL /dav/air/charts/2026-10-17/",
+		"PUT /dav/air/charts/2026-10-17/pm2p5.png",
+		"COPY /dav/air/charts/2026-10-17/pm2p5.png",
+		"PUT /dav/air/charts/2026-10-17/pm10p0.png",
+	)
+	if len(got) != 5 {
+		return
+	}
+	put, alias := got[2], got[3]
+	if put.body != "png" || put.header.Get("Content-Type") != "image/png" {
+		t.Errorf("put has body %q and content type %q", put.body, put.header.Get("Content-Type"))
+	}
+	if destination := alias.header.Get("Destination"); destination != server.URL+"/dav/air/charts/pm2p5-today.png" {
+		t.Errorf("copy destination is %q", destination)
+	}
+	if overwrite := alias.header.Get("Overwrite"); overwrite != "T" {
+		t.Errorf("copy overwrite is %q", overwrite)
+	}
+	for _, r := range got {
+		if r.user != "airquality:secret" {
+			t.Errorf("%s has basic auth %q", r, r.user)
+		}
+	}
+}
+
+func TestWebDAVCopyFallback(t *testing.T) {
+	for _, status := range []int{http.StatusMethodNotAllowed, http.StatusNotImplemented} {
+		server, requests := newWebDAVStandIn(t, func(method string, path string) int {
+			if method == "COPY" {
+				return status
+			}
+			return http.StatusCreated
+		})
+		p, err := NewWebDAVPublisher(server.URL, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = p.Publish(writeTemp(t, "png"), "pm2p5-2026-10-17.png")
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = p.Alias("pm2p5-today.png", "pm2p5-2026-10-17.png")
+		if err != nil {
+			t.Fatalf("%d: %v", status, err)
+		}
+
+		// without the local file there is nothing to upload again
+		//
+		err = p.Alias("pm10p0-today.png", "pm10p0-2026-10-17.png")
+		if err == nil {
+			t.Errorf("%d: aliasing a file never published succeeded", status)
+		}
+
+		got := requests()
+		checkRequests(t, got,
+			"PUT /pm2p5-2026-10-17.png",
+			"COPY /pm2p5-2026-10-17.png",
+			"PUT /pm2p5-today.png",
+			"COPY /pm10p0-2026-10-17.png",
+		)
+		if len(got) == 4 && got[2].body != "png" {
+			t.Errorf("%d: fallback put has body %q", status, got[2].body)
+		}
+		if len(got) > 0 && got[0].user != ":" {
+			t.Errorf("%d: sent basic auth %q without a user", status, got[0].user)
+		}
+	}
+}
+
+func TestWebDAVErrors(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		method string
+		status int
+		want   string
+	}{
+		{"mkcol", "MKCOL", http.StatusForbidden, "webdav mkcol 2026-10-17: 403 Forbidden"},
+		{"put", http.MethodPut, http.StatusInsufficientStorage, "webdav put 2026-10-17/pm2p5.png: 507 Insufficient Storage"},
+		{"copy", "COPY", http.StatusPreconditionFailed, "webdav copy 2026-10-17/pm2p5.png: 412 Precondition Failed"},
+	} {
+		server, _ := newWebDAVStandIn(t, func(method string, path string) int {
+			if method == test.method {
+				return test.status
+			}
+			return http.StatusCreated
+		})
+		p, err := NewWebDAVPublisher(server.URL, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = p.Publish(writeTemp(t, "png"), "2026-10-17/pm2p5.png")
+		if err == nil {
+			err = p.Alias("pm2p5-today.png", "2026-10-17/pm2p5.png")
+		}
+		if err == nil || err.Error() != test.want {
+			t.Errorf("%s: got %v, want %s", test.name, err, test.want)
+		}
+	}
+}